@@ -1,6 +1,9 @@
 package commands
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/zond/hackyhack/client/util"
 	"github.com/zond/hackyhack/proc/interfaces"
 	"github.com/zond/hackyhack/proc/messages"
@@ -16,6 +19,11 @@ func (d *Default) Say(what string) *messages.Error {
 		Metadata: map[string]string{
 			messages.MetadataPayload: what,
 		},
+		// Carried things and the rooms next door hear it too.
+		Propagation: &messages.Propagation{
+			Depth: 1,
+			Exits: 1,
+		},
 	})
 }
 
@@ -34,6 +42,15 @@ func (d *Default) Ident(what string) *messages.Error {
 	return nil
 }
 
+func (d *Default) Quota(what string) *messages.Error {
+	quota, err := util.GetQuota(d.M)
+	if err != nil {
+		return err
+	}
+	util.SendToClient(d.M, quota.String())
+	return nil
+}
+
 func (d *Default) look() *messages.Error {
 	containerId, err := util.GetContainer(d.M, d.M.GetResource())
 	if err != nil {
@@ -55,16 +72,227 @@ func (d *Default) look() *messages.Error {
 	if err != nil {
 		return err
 	}
+	exits, err := util.GetExits(d.M, containerId)
+	if err != nil && !util.IsNoSuchMethod(err) {
+		return err
+	}
 
 	if longDesc != "" {
 		util.SendToClient(d.M, util.Sprintf("%v\n%v\n\n%v\n", util.Capitalize(shortDesc.IndefArticlize()), longDesc, descs.Enumerate()))
 	} else {
 		util.SendToClient(d.M, util.Sprintf("%v\n\n%v\n", util.Capitalize(shortDesc.IndefArticlize()), descs.Enumerate()))
 	}
+	if len(exits) > 0 {
+		names := []string{}
+		for name := range exits {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		util.SendToClient(d.M, util.Sprintf("Exits: %v\n", strings.Join(names, ", ")))
+	}
+	d.gmcp("Room.Info", map[string]interface{}{
+		"id":    containerId,
+		"name":  shortDesc.Value,
+		"exits": exits,
+	})
+	d.gmcp("Char.Items.List", map[string]interface{}{
+		"location": "room",
+		"items":    items(siblings, descs),
+	})
 
 	return nil
 }
 
+// gmcp sends data for MUD clients to show next to the text. Clients without GMCP only get the text.
+func (d *Default) gmcp(pkg string, payload interface{}) {
+	util.SendGMCP(d.M, pkg, payload)
+}
+
+// items describes resources with their short descriptions for GMCP.
+func items(resources []string, descs messages.ShortDescs) []map[string]string {
+	result := make([]map[string]string, len(resources))
+	for index, resource := range resources {
+		result[index] = map[string]string{
+			"id":   resource,
+			"name": descs[index].Value,
+		}
+	}
+	return result
+}
+
+func (d *Default) Go(what string) *messages.Error {
+	containerId, err := util.GetContainer(d.M, d.M.GetResource())
+	if err != nil {
+		return err
+	}
+	exits, err := util.GetExits(d.M, containerId)
+	if err != nil && !util.IsNoSuchMethod(err) {
+		return err
+	}
+	destination, found := exits[strings.ToLower(what)]
+	if !found {
+		util.SendToClient(d.M, "You can't go that way.\n")
+		return nil
+	}
+	if err := util.Move(d.M, destination); err != nil {
+		return err
+	}
+	return d.look()
+}
+
+func (d *Default) North(what string) *messages.Error { return d.Go("north") }
+func (d *Default) South(what string) *messages.Error { return d.Go("south") }
+func (d *Default) East(what string) *messages.Error  { return d.Go("east") }
+func (d *Default) West(what string) *messages.Error  { return d.Go("west") }
+func (d *Default) Up(what string) *messages.Error    { return d.Go("up") }
+func (d *Default) Down(what string) *messages.Error  { return d.Go("down") }
+func (d *Default) N(what string) *messages.Error     { return d.Go("north") }
+func (d *Default) S(what string) *messages.Error     { return d.Go("south") }
+func (d *Default) E(what string) *messages.Error     { return d.Go("east") }
+func (d *Default) W(what string) *messages.Error     { return d.Go("west") }
+func (d *Default) U(what string) *messages.Error     { return d.Go("up") }
+func (d *Default) D(what string) *messages.Error     { return d.Go("down") }
+
+func (d *Default) Take(what string) *messages.Error {
+	matches, err := util.Identify(d.M, what)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		util.SendToClient(d.M, "Take what?\n")
+		return nil
+	}
+	return util.Take(d.M, matches[0])
+}
+
+func (d *Default) Get(what string) *messages.Error {
+	return d.Take(what)
+}
+
+func (d *Default) Drop(what string) *messages.Error {
+	matches, err := util.Identify(d.M, what)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		util.SendToClient(d.M, "Drop what?\n")
+		return nil
+	}
+	return util.Drop(d.M, matches[0])
+}
+
+func (d *Default) Give(what string) *messages.Error {
+	parts := strings.SplitN(what, " to ", 2)
+	if len(parts) != 2 {
+		util.SendToClient(d.M, "Give what to whom?\n")
+		return nil
+	}
+	items, err := util.Identify(d.M, strings.TrimSpace(parts[0]))
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		util.SendToClient(d.M, "Give what?\n")
+		return nil
+	}
+	recipients, err := util.Identify(d.M, strings.TrimSpace(parts[1]))
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		util.SendToClient(d.M, "Give it to whom?\n")
+		return nil
+	}
+	return util.Give(d.M, items[0], recipients[0])
+}
+
+func (d *Default) Pwn(what string) *messages.Error {
+	matches, err := util.Identify(d.M, what)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		util.SendToClient(d.M, "Pwn what?\n")
+		return nil
+	}
+	_, err = util.Challenge(d.M, matches[0])
+	return err
+}
+
+func (d *Default) Logs(what string) *messages.Error {
+	resource := d.M.GetResource()
+	if what != "" {
+		matches, err := util.Identify(d.M, what)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			util.SendToClient(d.M, "Logs of what?\n")
+			return nil
+		}
+		resource = matches[0]
+	}
+	lines, err := util.GetLogs(d.M, resource)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		util.SendToClient(d.M, "Nothing logged.\n")
+		return nil
+	}
+	for _, line := range lines {
+		util.SendToClient(d.M, util.Sprintf("%v %v: %v\n", line.Time.Format("15:04:05"), line.Source, line.Text))
+	}
+	return nil
+}
+
+func (d *Default) Password(what string) *messages.Error {
+	parts := strings.Fields(what)
+	if len(parts) != 2 {
+		util.SendToClient(d.M, "Usage: password OLD NEW\n")
+		return nil
+	}
+	if err := util.ChangePassword(d.M, parts[0], parts[1]); err != nil {
+		return err
+	}
+	util.SendToClient(d.M, "Password changed.\n")
+	return nil
+}
+
+func (d *Default) Webauth(what string) *messages.Error {
+	link, err := util.WebAuth(d.M)
+	if err != nil {
+		return err
+	}
+	util.SendToClient(d.M, util.Sprintf("Open %v within a few minutes to log into the editor.\n", link))
+	return nil
+}
+
+func (d *Default) Inventory(what string) *messages.Error {
+	content, err := util.GetContent(d.M, d.M.GetResource())
+	if err != nil && !util.IsNoSuchMethod(err) {
+		return err
+	}
+	descs, err := util.GetShortDescs(d.M, content)
+	if err != nil {
+		return err
+	}
+	d.gmcp("Char.Items.List", map[string]interface{}{
+		"location": "inv",
+		"items":    items(content, descs),
+	})
+	if len(content) == 0 {
+		util.SendToClient(d.M, "You are carrying nothing.\n")
+		return nil
+	}
+	util.SendToClient(d.M, util.Sprintf("You are carrying %v.\n", descs.Enumerate()))
+	return nil
+}
+
+func (d *Default) I(what string) *messages.Error {
+	return d.Inventory(what)
+}
+
 func (d *Default) L(what string) *messages.Error {
 	if what == "" {
 		return d.look()
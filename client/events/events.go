@@ -16,6 +16,10 @@ func (h *DefaultHandler) Event(ctx *messages.Context, ev *messages.Event) bool {
 	}
 	switch ev.Type {
 	case messages.EventTypeSay:
+		if ev.Attenuation > 0 {
+			util.SendToClient(h.M, util.Sprintf("You hear a distant voice say %q.\n", ev.Metadata[messages.MetadataPayload]))
+			return true
+		}
 		subject := "something"
 		verb := ""
 		if ev.Source == h.M.GetResource() {
@@ -29,6 +33,59 @@ func (h *DefaultHandler) Event(ctx *messages.Context, ev *messages.Event) bool {
 			verb = "says"
 		}
 		util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v %v %q.\n", subject, verb, ev.Metadata[messages.MetadataPayload])))
+	case messages.EventTypeLeave:
+		if exit := ev.Metadata[messages.MetadataExit]; exit != "" {
+			util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v leaves %v.\n", ev.SourceShortDesc.DefArticlize(), exit)))
+		} else {
+			util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v leaves.\n", ev.SourceShortDesc.DefArticlize())))
+		}
+	case messages.EventTypeArrive:
+		if ev.Source == h.M.GetResource() {
+			return true
+		}
+		if exit := ev.Metadata[messages.MetadataExit]; exit != "" {
+			util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v arrives from the %v.\n", ev.SourceShortDesc.DefArticlize(), exit)))
+		} else {
+			util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v arrives.\n", ev.SourceShortDesc.DefArticlize())))
+		}
+	case messages.EventTypeTake, messages.EventTypeDrop, messages.EventTypeGive:
+		subject := ev.SourceShortDesc.DefArticlize()
+		verb := map[messages.EventType]string{
+			messages.EventTypeTake: "takes",
+			messages.EventTypeDrop: "drops",
+			messages.EventTypeGive: "gives",
+		}[ev.Type]
+		if ev.Source == h.M.GetResource() {
+			subject = "you"
+			verb = map[messages.EventType]string{
+				messages.EventTypeTake: "take",
+				messages.EventTypeDrop: "drop",
+				messages.EventTypeGive: "give",
+			}[ev.Type]
+		}
+		object := ev.ObjectShortDesc.IndefArticlize()
+		if ev.Type == messages.EventTypeGive {
+			target := ev.TargetShortDesc.DefArticlize()
+			if ev.Target == h.M.GetResource() {
+				target = "you"
+			}
+			util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v %v %v to %v.\n", subject, verb, object, target)))
+		} else {
+			util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v %v %v.\n", subject, verb, object)))
+		}
+	case messages.EventTypeChallenge:
+		subject := ev.SourceShortDesc.DefArticlize()
+		format := map[bool]string{true: "%v pwns %v!\n", false: "%v tries to pwn %v, but fails.\n"}
+		if ev.Source == h.M.GetResource() {
+			subject = "you"
+			format = map[bool]string{true: "%v pwn %v!\n", false: "%v try to pwn %v, but fail.\n"}
+		}
+		pwned := ev.Metadata[messages.MetadataPwned] == "true"
+		util.SendToClient(h.M, util.Capitalize(util.Sprintf(format[pwned], subject, ev.ObjectShortDesc.DefArticlize())))
+	case messages.EventTypeHeartbeat:
+	case messages.EventTypeBroken:
+		// Broken resources can't describe themselves.
+		util.SendToClient(h.M, "Something shudders and stops working.\n")
 	case messages.EventTypeDestruct:
 		util.SendToClient(h.M, util.Capitalize(util.Sprintf("%v disappears.\n", ev.SourceShortDesc.IndefArticlize())))
 	case messages.EventTypeConstruct:
@@ -2,6 +2,7 @@ package util
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -50,7 +51,7 @@ func (als AttentionLevels) Ignored(m interfaces.MCP, event *messages.Event) bool
 	if event.Type == messages.EventTypeRequest {
 		key = fmt.Sprintf("%v.%v", event.Type, event.Request.Method)
 	} else {
-		key = fmt.Sprintf("%v.-", event.Type, event.Request.Method)
+		key = fmt.Sprintf("%v.-", event.Type)
 	}
 	al, found := als[key]
 	if !found {
@@ -63,7 +64,8 @@ func (als AttentionLevels) Ignored(m interfaces.MCP, event *messages.Event) bool
 var DefaultAttentionLevels = (&AttentionLevels{}).
 	AddMethod(messages.MethodGetShortDesc, AttentionLevelNone).
 	AddMethod(messages.MethodGetContent, AttentionLevelNotContainer).
-	AddMethod(messages.MethodGetLongDesc, AttentionLevelMe)
+	AddMethod(messages.MethodGetLongDesc, AttentionLevelMe).
+	AddMethod(messages.MethodGetExits, AttentionLevelNone)
 
 type sdCache struct {
 	m map[string]*messages.ShortDesc
@@ -135,6 +137,11 @@ var (
 		SecondPerson: "inspect",
 		ThirdPerson:  "inspects",
 	}
+	LookForExits = &messages.Verb{
+		SecondPerson: "look for exits",
+		ThirdPerson:  "looks for exits",
+		Intransitive: true,
+	}
 )
 
 func EmitEvent(m interfaces.MCP, ev *messages.Event) *messages.Error {
@@ -145,14 +152,197 @@ func EmitEvent(m interfaces.MCP, ev *messages.Event) *messages.Error {
 	return merr
 }
 
-func Subscribe(m interfaces.MCP, sub *messages.Subscription) *messages.Error {
+// Subscribe returns the id of the subscription, which is the same for identical subscriptions.
+func Subscribe(m interfaces.MCP, sub *messages.Subscription) (string, *messages.Error) {
+	var id string
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodSubscribe, []interface{}{sub}, &[]interface{}{&id, &merr}); err != nil {
+		return "", err
+	}
+	return id, merr
+}
+
+// Schedule makes the router call a method of the calling resource later, and returns the id of the timer.
+func Schedule(m interfaces.MCP, t *messages.Timer) (string, *messages.Error) {
+	var id string
 	var merr *messages.Error
-	if err := m.Call(nil, m.GetResource(), messages.MethodSubscribe, []interface{}{sub}, &[]interface{}{&merr}); err != nil {
+	if err := m.Call(nil, m.GetResource(), messages.MethodSchedule, []interface{}{t}, &[]interface{}{&id, &merr}); err != nil {
+		return "", err
+	}
+	return id, merr
+}
+
+func Unschedule(m interfaces.MCP, id string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodUnschedule, []string{id}, &[]interface{}{&merr}); err != nil {
 		return err
 	}
 	return merr
 }
 
+func GetTimers(m interfaces.MCP) ([]messages.Timer, *messages.Error) {
+	var timers []messages.Timer
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGetTimers, nil, &[]interface{}{&timers, &merr}); err != nil {
+		return nil, err
+	}
+	return timers, merr
+}
+
+// GetState returns the value the calling resource stored under key.
+func GetState(m interfaces.MCP, key string) (string, *messages.Error) {
+	var value string
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGetState, []string{key}, &[]interface{}{&value, &merr}); err != nil {
+		return "", err
+	}
+	return value, merr
+}
+
+func SetState(m interfaces.MCP, key, value string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodSetState, []string{key, value}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func DeleteState(m interfaces.MCP, key string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodDeleteState, []string{key}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func ListState(m interfaces.MCP) ([]string, *messages.Error) {
+	var keys []string
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodListState, nil, &[]interface{}{&keys, &merr}); err != nil {
+		return nil, err
+	}
+	return keys, merr
+}
+
+func Unsubscribe(m interfaces.MCP, id string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodUnsubscribe, []string{id}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func GetSubscriptions(m interfaces.MCP) ([]messages.Subscription, *messages.Error) {
+	var subs []messages.Subscription
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGetSubs, nil, &[]interface{}{&subs, &merr}); err != nil {
+		return nil, err
+	}
+	return subs, merr
+}
+
+func Create(m interfaces.MCP, c *messages.Create) (string, *messages.Error) {
+	var id string
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodCreate, []interface{}{c}, &[]interface{}{&id, &merr}); err != nil {
+		return "", err
+	}
+	return id, merr
+}
+
+func SetPermissions(m interfaces.MCP, perms map[string]messages.Permission) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodSetPerms, []interface{}{perms}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+// ChangePassword changes the password of the user controlling the calling resource.
+func ChangePassword(m interfaces.MCP, oldPassword, newPassword string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodChangePass, []string{oldPassword, newPassword}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+// WebAuth returns a link logging the user controlling the calling resource into the web server once.
+func WebAuth(m interfaces.MCP) (string, *messages.Error) {
+	var link string
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodWebAuth, nil, &[]interface{}{&link, &merr}); err != nil {
+		return "", err
+	}
+	return link, merr
+}
+
+// GetTerminal returns the terminal of the client controlling the calling resource.
+func GetTerminal(m interfaces.MCP) (*messages.Terminal, *messages.Error) {
+	var terminal *messages.Terminal
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGetTerminal, nil, &[]interface{}{&terminal, &merr}); err != nil {
+		return nil, err
+	}
+	return terminal, merr
+}
+
+// SendGMCP sends payload encoded as JSON to the client controlling the calling resource, as the
+// GMCP message pkg, for MUD clients to show in maps, panels and bars.
+func SendGMCP(m interfaces.MCP, pkg string, payload interface{}) *messages.Error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeJSONEncodeParameters,
+		}
+	}
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodSendGMCP, []string{pkg, string(b)}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+// Wrap breaks the lines of s at spaces so that they fit in width columns where possible.
+// Widths below 1 leave s as is.
+func Wrap(s string, width int) string {
+	if width < 1 {
+		return s
+	}
+	result := &bytes.Buffer{}
+	for index, line := range strings.Split(s, "\n") {
+		if index > 0 {
+			result.WriteString("\n")
+		}
+		column := 0
+		for wordIndex, word := range strings.Split(line, " ") {
+			wordWidth := len([]rune(word))
+			if wordIndex > 0 {
+				if column > 0 && column+1+wordWidth > width {
+					result.WriteString("\n")
+					column = 0
+				} else {
+					result.WriteString(" ")
+					column++
+				}
+			}
+			result.WriteString(word)
+			column += wordWidth
+		}
+	}
+	return result.String()
+}
+
+func GetQuota(m interfaces.MCP) (*messages.Quota, *messages.Error) {
+	var quota *messages.Quota
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGetQuota, nil, &[]interface{}{&quota, &merr}); err != nil {
+		return nil, err
+	}
+	return quota, merr
+}
+
 func GetContainer(m interfaces.MCP, resource string) (string, *messages.Error) {
 	var container string
 	var merr *messages.Error
@@ -179,6 +369,56 @@ func GetContent(m interfaces.MCP, resource string) ([]string, *messages.Error) {
 	return content, merr
 }
 
+func GetExits(m interfaces.MCP, resource string) (map[string]string, *messages.Error) {
+	var exits map[string]string
+	var merr *messages.Error
+	if err := m.Call(LookForExits, resource, messages.MethodGetExits, nil, &[]interface{}{&exits, &merr}); err != nil {
+		return nil, err
+	}
+	return exits, merr
+}
+
+func Move(m interfaces.MCP, destination string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodMove, []string{destination}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func Challenge(m interfaces.MCP, target string) (bool, *messages.Error) {
+	var pwned bool
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodChallenge, []string{target}, &[]interface{}{&pwned, &merr}); err != nil {
+		return false, err
+	}
+	return pwned, merr
+}
+
+func Take(m interfaces.MCP, item string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodTake, []string{item}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func Drop(m interfaces.MCP, item string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodDrop, []string{item}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func Give(m interfaces.MCP, item, recipient string) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGive, []string{item, recipient}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
 func GetLongDesc(m interfaces.MCP, resource string) (string, *messages.Error) {
 	var desc string
 	var merr *messages.Error
@@ -242,6 +482,28 @@ func Logf(f string, i ...interface{}) {
 	log.Printf(f, i...)
 }
 
+// ResourceLog logs to the log of the calling resource, which its owner can read in game or in the editor.
+func ResourceLog(m interfaces.MCP, i ...interface{}) *messages.Error {
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodLog, []string{fmt.Sprint(i...)}, &[]interface{}{&merr}); err != nil {
+		return err
+	}
+	return merr
+}
+
+func ResourceLogf(m interfaces.MCP, f string, i ...interface{}) *messages.Error {
+	return ResourceLog(m, fmt.Sprintf(f, i...))
+}
+
+func GetLogs(m interfaces.MCP, resource string) ([]messages.LogLine, *messages.Error) {
+	var lines []messages.LogLine
+	var merr *messages.Error
+	if err := m.Call(nil, m.GetResource(), messages.MethodGetLogs, []string{resource}, &[]interface{}{&lines, &merr}); err != nil {
+		return nil, err
+	}
+	return lines, merr
+}
+
 func Spewf(f string, i ...interface{}) string {
 	return spew.Sprintf(f, i...)
 }
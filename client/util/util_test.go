@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"a few short words", 0, "a few short words"},
+		{"a few short words", 80, "a few short words"},
+		{"a few short words", 7, "a few\nshort\nwords"},
+		{"a few short words", 11, "a few short\nwords"},
+		{"exactly ten", 11, "exactly ten"},
+		{"unbreakable words stay whole", 5, "unbreakable\nwords\nstay\nwhole"},
+		{"åäö åäö", 3, "åäö\nåäö"},
+		{"first line\nsecond line", 6, "first\nline\nsecond\nline"},
+		{"", 10, ""},
+	} {
+		if got := Wrap(tc.s, tc.width); got != tc.want {
+			t.Errorf("Wrap(%q, %v): got %q, wanted %q", tc.s, tc.width, got, tc.want)
+		}
+	}
+}
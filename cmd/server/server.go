@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/zond/hackyhack/proc/build"
+	"github.com/zond/hackyhack/proc/mcp"
 	"github.com/zond/hackyhack/server"
 	"github.com/zond/hackyhack/server/persist"
 )
 
+const (
+	// shutdownTimeout is how long to wait for web requests to finish when shutting down.
+	shutdownTimeout = 5 * time.Second
+)
+
 const (
 	certPem = `-----BEGIN CERTIFICATE-----
 MIIC+zCCAeOgAwIBAgIJAJFppcTeNOxCMA0GCSqGSIb3DQEBBQUAMBQxEjAQBgNV
@@ -62,6 +76,9 @@ fauKTZKIO5xJUx0HYLL1diaGtTT08pvnNW6lnZvaowluNJ2ztNhd
 func main() {
 	socketAddr := flag.String("loginAddr", ":6000", "Where to listen for sockets")
 	httpAddr := flag.String("httpAddr", ":8080", "Where to listen for http")
+	dataDir := flag.String("dataDir", "", "Where to store the database, if empty the world will only be kept in memory")
+	webURL := flag.String("webURL", "", "The base URL players reach the web server at, if empty https://localhost followed by httpAddr")
+	sandbox := flag.Bool("sandbox", true, "Whether to run resource code in Linux namespaces with a seccomp filter and a read-only root, requires unprivileged user namespaces")
 
 	flag.Parse()
 
@@ -70,9 +87,50 @@ func main() {
 		panic(err)
 	}
 
+	buildDir := filepath.Join(os.TempDir(), "hackyhack-build")
+	var backend persist.Backend = persist.NewMem()
+	var bolt *persist.Bolt
+	if *dataDir != "" {
+		buildDir = filepath.Join(*dataDir, "build")
+		if err := os.MkdirAll(*dataDir, 0700); err != nil {
+			log.Fatal(err)
+		}
+		if bolt, err = persist.NewBolt(filepath.Join(*dataDir, "hackyhack.db")); err != nil {
+			log.Fatal(err)
+		}
+		backend = bolt
+	}
+
+	buildCache, err := build.NewCache(buildDir, build.DefaultMaxEntries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var sb *mcp.Sandbox
+	if *sandbox {
+		// Children have no business reading the database or each other's binaries.
+		hiding := *mcp.DefaultSandbox
+		hiding.Hidden = []string{buildDir}
+		if *dataDir != "" {
+			hiding.Hidden = append(hiding.Hidden, *dataDir)
+		}
+		sb = &hiding
+		if err := sb.Check(); err != nil {
+			log.Fatalf("Unable to sandbox resource code, pass -sandbox=false to run it unsandboxed: %v", err)
+		}
+	}
+
 	s, err := server.New(&persist.Persister{
-		Backend: persist.NewMem(),
-	})
+		Backend: backend,
+	}, buildCache, sb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *webURL == "" {
+		*webURL = fmt.Sprintf("https://localhost%v", *httpAddr)
+	}
+	s.WebURL(*webURL)
 
 	httpServer := &http.Server{
 		Addr:    *httpAddr,
@@ -89,12 +147,36 @@ func main() {
 		panic(err)
 	}
 
-	if err != nil {
-		log.Fatal(err)
-	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.ServeLogin(socketListener)
+	}()
 	go func() {
-		log.Fatal(s.ServeLogin(socketListener))
+		errs <- httpServer.ListenAndServeTLS("", "")
 	}()
 
-	log.Fatal(httpServer.ListenAndServeTLS("", ""))
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		log.Printf("Got %v, shutting down", sig)
+		err = nil
+	case err = <-errs:
+	}
+
+	// Stop taking new players and requests before closing the database under them.
+	socketListener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Shutting down web server: %v", err)
+	}
+	if bolt != nil {
+		if err := bolt.Close(); err != nil {
+			log.Printf("Closing database: %v", err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,225 @@
+package build
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxEntries = 256
+)
+
+// LibraryPackages are the packages code links against. Their source is part of the key, so that
+// binaries linked against an older version of them aren't used after upgrading the server.
+var LibraryPackages = []string{
+	"github.com/zond/hackyhack/proc/slave",
+	"github.com/zond/hackyhack/client/...",
+}
+
+// Error is returned when the code doesn't compile.
+type Error struct {
+	Output string
+}
+
+func (e *Error) Error() string {
+	return e.Output
+}
+
+type flyingBuild struct {
+	waitGroup sync.WaitGroup
+	path      string
+	err       error
+}
+
+// Cache compiles code to binaries in a directory, keyed by code, toolchain version and library source,
+// so that each piece of code only gets compiled once.
+type Cache struct {
+	dir        string
+	maxEntries int
+	toolchain  string
+	library    string
+	flying     map[string]*flyingBuild
+	lock       sync.Mutex
+}
+
+func NewCache(dir string, maxEntries int) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	toolchain, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("go version failed: %v", err)
+	}
+	library, err := libraryHash(LibraryPackages)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		dir:        dir,
+		maxEntries: maxEntries,
+		toolchain:  strings.TrimSpace(string(toolchain)),
+		library:    library,
+		flying:     map[string]*flyingBuild{},
+	}, nil
+}
+
+// libraryHash returns a hash of the source of packages and the packages they depend on, except the
+// standard library which the toolchain version covers.
+func libraryHash(packages []string) (string, error) {
+	output, err := exec.Command("go", append([]string{"list", "-deps", "-f", "{{if not .Standard}}{{.Dir}}{{end}}"}, packages...)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("go list failed: %v", err)
+	}
+	h := sha1.New()
+	for _, dir := range strings.Split(string(output), "\n") {
+		if dir == "" {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return "", err
+			}
+			if err := writeFields(h, file, string(b)); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFields writes fields to w, separated so that moving bytes between them changes the result.
+func writeFields(w io.Writer, fields ...string) error {
+	for _, field := range fields {
+		if _, err := io.WriteString(w, field); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) key(code string) (string, error) {
+	h := sha1.New()
+	if err := writeFields(h, c.toolchain, c.library, code); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Binary returns the path to a binary compiled from code, compiling it if necessary.
+func (c *Cache) Binary(code string) (string, error) {
+	key, err := c.key(code)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(c.dir, key)
+
+	c.lock.Lock()
+	if flying, found := c.flying[key]; found {
+		c.lock.Unlock()
+		flying.waitGroup.Wait()
+		return flying.path, flying.err
+	}
+	if _, err := os.Stat(path); err == nil {
+		c.lock.Unlock()
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			return "", err
+		}
+		return path, nil
+	} else if !os.IsNotExist(err) {
+		c.lock.Unlock()
+		return "", err
+	}
+	flying := &flyingBuild{}
+	flying.waitGroup.Add(1)
+	c.flying[key] = flying
+	c.lock.Unlock()
+
+	flying.path, flying.err = c.compile(key, code)
+
+	c.lock.Lock()
+	delete(c.flying, key)
+	c.lock.Unlock()
+	flying.waitGroup.Done()
+
+	if flying.err == nil {
+		if err := c.evict(); err != nil {
+			return "", err
+		}
+	}
+	return flying.path, flying.err
+}
+
+func (c *Cache) compile(key, code string) (string, error) {
+	src := filepath.Join(c.dir, fmt.Sprintf("%v.go", key))
+	if err := ioutil.WriteFile(src, []byte(code), 0600); err != nil {
+		return "", err
+	}
+	defer os.Remove(src)
+
+	path := filepath.Join(c.dir, key)
+	tmpPath := fmt.Sprintf("%v.tmp", path)
+	output, err := exec.Command("go", "build", "-o", tmpPath, src).CombinedOutput()
+	if _, isExit := err.(*exec.ExitError); isExit || len(output) > 0 {
+		os.Remove(tmpPath)
+		return "", &Error{
+			Output: string(output),
+		}
+	} else if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// evict removes the least recently used binaries until at most maxEntries remain.
+func (c *Cache) evict() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	binaries := []os.FileInfo{}
+	for _, info := range infos {
+		if !info.IsDir() && filepath.Ext(info.Name()) == "" {
+			binaries = append(binaries, info)
+		}
+	}
+	if len(binaries) <= c.maxEntries {
+		return nil
+	}
+	sort.Slice(binaries, func(i, j int) bool {
+		return binaries[i].ModTime().Before(binaries[j].ModTime())
+	})
+	for _, info := range binaries[:len(binaries)-c.maxEntries] {
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
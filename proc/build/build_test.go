@@ -0,0 +1,116 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const (
+	hello   = "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n"
+	goodbye = "package main\n\nfunc main() {\n\tprintln(\"goodbye\")\n}\n"
+)
+
+func testCache(t *testing.T, maxEntries int) *Cache {
+	dir, err := ioutil.TempDir("", "build_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	c, err := NewCache(dir, maxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func binary(t *testing.T, c *Cache, code string) string {
+	path, err := c.Binary(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func exists(t *testing.T, path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return true
+	} else if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return false
+}
+
+func TestHitAndMiss(t *testing.T) {
+	c := testCache(t, DefaultMaxEntries)
+	path := binary(t, c, hello)
+	// Hits don't compile again, so they return whatever the cache has.
+	if err := ioutil.WriteFile(path, []byte("cached"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary(t, c, hello); got != path {
+		t.Errorf("Got %q, wanted %q", got, path)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "cached" {
+		t.Errorf("Got %q, %v, wanted the cached binary kept", b, err)
+	}
+	if other := binary(t, c, goodbye); other == path {
+		t.Errorf("Wanted different code to miss")
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	c := testCache(t, DefaultMaxEntries)
+	if _, err := c.Binary("package main\n\nfunc main() {\n\tmissing()\n}\n"); err == nil {
+		t.Fatalf("Wanted broken code to fail")
+	} else if _, ok := err.(*Error); !ok {
+		t.Errorf("Got %v, wanted a build error", err)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := testCache(t, 1)
+	first := binary(t, c, hello)
+	second := binary(t, c, goodbye)
+	if exists(t, first) {
+		t.Errorf("Wanted the least recently used binary evicted")
+	}
+	if !exists(t, second) {
+		t.Errorf("Wanted the last binary kept")
+	}
+}
+
+func TestKeyChanges(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Cache)
+	}{
+		{"toolchain", func(c *Cache) { c.toolchain += " upgraded" }},
+		{"library", func(c *Cache) { c.library += " upgraded" }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testCache(t, DefaultMaxEntries)
+			path := binary(t, c, hello)
+			tc.change(c)
+			if got := binary(t, c, hello); got == path {
+				t.Errorf("Wanted a %v change to miss", tc.name)
+			}
+		})
+	}
+}
+
+func TestLibraryHash(t *testing.T) {
+	c := testCache(t, DefaultMaxEntries)
+	if c.library == "" {
+		t.Fatalf("Wanted a library hash")
+	}
+	other, err := libraryHash([]string{"github.com/zond/hackyhack/proc/messages"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == c.library {
+		t.Errorf("Wanted the hash to depend on the packages")
+	}
+}
@@ -7,4 +7,10 @@ var (
 	ErrOnlyRegisterOnce    = errors.New("Can only Register once.")
 	ErrNoSuchResource      = errors.New("No such resource.")
 	ErrUnavailableResource = errors.New("Unavailable resource.")
+	ErrTimeout             = errors.New("Timeout.")
+	ErrProcessDied         = errors.New("Process died.")
+	ErrAlreadyStopped      = errors.New("Already stopped.")
+	ErrBroken              = errors.New("Broken, since its code crash-loops.")
+	ErrNotHosted           = errors.New("Resource not hosted by the requesting process.")
+	ErrSuspended           = errors.New("Suspended until the CPU budget of its owner is refilled.")
 )
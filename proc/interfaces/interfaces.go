@@ -10,6 +10,17 @@ type Subscriber interface {
 	Event(*messages.Event) error
 }
 
+// Movable resources can be taken, dropped and given if they allow it.
+type Movable interface {
+	AllowMove(*messages.Transfer) (bool, *messages.Error)
+}
+
+// Transformers can be pwned by resources mirroring their transform function, meaning that the
+// transform function of the Transformer turns the output of the mirror back into its input.
+type Transformer interface {
+	Transform(string) (string, *messages.Error)
+}
+
 type Destructible interface {
 	Destroy()
 }
@@ -1,36 +1,42 @@
 package mcp
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/zond/hackyhack/logging"
 	"github.com/zond/hackyhack/proc"
+	"github.com/zond/hackyhack/proc/build"
+	"github.com/zond/hackyhack/proc/errors"
 	"github.com/zond/hackyhack/proc/messages"
 )
 
 var nextRequestId uint64
 
 const (
-	clientRestartTimeout = time.Second * 5
+	DefaultRequestTimeout = time.Second * 10
+	// Dead children are restarted after defaultMinRestartDelay, doubled for each crash in a row up to
+	// defaultMaxRestartDelay.
+	defaultMinRestartDelay = time.Second
+	defaultMaxRestartDelay = time.Minute
+	// A child dying within defaultCrashLoopUptime of starting, or failing to start, counts as a crash,
+	// and crashLoopThreshold crashes in a row is a crash loop.
+	defaultCrashLoopUptime = time.Second * 30
+	crashLoopThreshold     = 5
 )
 
 type MCP struct {
 	code              string
-	path              string
+	buildCache        *build.Cache
 	childStdin        io.WriteCloser
 	childStdinEncoder *json.Encoder
 	childStdout       io.ReadCloser
@@ -41,33 +47,47 @@ type MCP struct {
 	flyingConstructs  map[string]*flyingConstruct
 	flyingDestructs   map[string]*flyingDestruct
 	flyingLock        sync.Mutex
+	hosted            map[string]bool
+	hostedLock        sync.RWMutex
 	emitLock          sync.Mutex
 	stderrHandler     func([]byte)
+	crashLoopHandler  func(error)
+	sandbox           *Sandbox
+	started           time.Time
+	crashes           int
+	minRestartDelay   time.Duration
+	maxRestartDelay   time.Duration
+	crashLoopUptime   time.Duration
 	debugHandler      logging.Outputter
 	resourceFinder    proc.ResourceFinder
+	requestTimeout    time.Duration
 	stopped           int32
+	suspended         int32
+	broken            int32
+	killed            int32
 	count             int64
+	deadCPU           int64
 }
 
-func New(code string, resourceFinder proc.ResourceFinder) (*MCP, error) {
-	h := sha1.New()
-	if _, err := h.Write([]byte(code)); err != nil {
-		return nil, err
-	}
-
+func New(code string, buildCache *build.Cache, resourceFinder proc.ResourceFinder) (*MCP, error) {
 	mcp := &MCP{
 		code:             code,
-		path:             filepath.Join(os.TempDir(), fmt.Sprintf("%s.go", hex.EncodeToString(h.Sum(nil)))),
+		buildCache:       buildCache,
 		flyingRequests:   map[string]*flyingRequest{},
 		flyingConstructs: map[string]*flyingConstruct{},
 		flyingDestructs:  map[string]*flyingDestruct{},
+		hosted:           map[string]bool{},
 		stderrHandler: func(b []byte) {
 			log.Printf("STDERR: %q", b)
 		},
 		debugHandler: func(f string, i ...interface{}) {
 			log.Print(spew.Sprintf(f, i...))
 		},
-		resourceFinder: resourceFinder,
+		resourceFinder:  resourceFinder,
+		requestTimeout:  DefaultRequestTimeout,
+		minRestartDelay: defaultMinRestartDelay,
+		maxRestartDelay: defaultMaxRestartDelay,
+		crashLoopUptime: defaultCrashLoopUptime,
 	}
 	return mcp, nil
 }
@@ -81,35 +101,86 @@ func (m *MCP) StderrHandler(f func([]byte)) *MCP {
 	return m
 }
 
+// CrashLoopHandler sets a function to call with the last cause of death when the child keeps dying
+// right after starting.
+func (m *MCP) CrashLoopHandler(f func(error)) *MCP {
+	m.crashLoopHandler = f
+	return m
+}
+
+func (m *MCP) RequestTimeout(d time.Duration) *MCP {
+	m.requestTimeout = d
+	return m
+}
+
 type flyingRequest struct {
-	waitGroup  sync.WaitGroup
+	done       chan struct{}
 	response   *messages.Response
 	resourceId string
 }
 
 type flyingConstruct struct {
-	waitGroup sync.WaitGroup
+	done      chan struct{}
 	resource  string
 	construct *messages.Deconstruct
+	err       error
 }
 
 type flyingDestruct struct {
-	waitGroup sync.WaitGroup
-	resource  string
-	destruct  *messages.Deconstruct
+	done     chan struct{}
+	resource string
+	destruct *messages.Deconstruct
+	err      error
+}
+
+// wait returns false if done wasn't closed before timeout.
+func wait(done chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// host records whether resource is constructed in the child, and thus may make requests through it.
+func (m *MCP) host(resource string, hosted bool) {
+	m.hostedLock.Lock()
+	defer m.hostedLock.Unlock()
+	if hosted {
+		m.hosted[resource] = true
+	} else {
+		delete(m.hosted, resource)
+	}
+}
+
+func (m *MCP) hosts(resource string) bool {
+	m.hostedLock.RLock()
+	defer m.hostedLock.RUnlock()
+	return m.hosted[resource]
 }
 
 func (m *MCP) Destruct(resource string) (bool, error) {
+	destructed, err := m.destruct(resource)
+	if destructed {
+		m.host(resource, false)
+	}
+	return destructed, err
+}
+
+func (m *MCP) destruct(resource string) (bool, error) {
 	destruct := &messages.Deconstruct{
 		Resource: resource,
 		Id:       fmt.Sprintf("%X", atomic.AddUint64(&nextRequestId, 1)),
 	}
 
 	flying := &flyingDestruct{
+		done:     make(chan struct{}),
 		resource: resource,
 	}
 
-	flying.waitGroup.Add(1)
 	m.flyingLock.Lock()
 	m.flyingDestructs[destruct.Id] = flying
 	m.flyingLock.Unlock()
@@ -118,10 +189,26 @@ func (m *MCP) Destruct(resource string) (bool, error) {
 		Type:     messages.BlobTypeDestruct,
 		Destruct: destruct,
 	}); err != nil {
+		m.flyingLock.Lock()
+		delete(m.flyingDestructs, destruct.Id)
+		m.flyingLock.Unlock()
 		return false, err
 	}
 
-	flying.waitGroup.Wait()
+	if !wait(flying.done, m.requestTimeout) {
+		m.flyingLock.Lock()
+		_, found := m.flyingDestructs[destruct.Id]
+		delete(m.flyingDestructs, destruct.Id)
+		m.flyingLock.Unlock()
+		if found {
+			return false, errors.ErrTimeout
+		}
+		<-flying.done
+	}
+
+	if flying.err != nil {
+		return false, flying.err
+	}
 
 	if flying.destruct.Deconstructed {
 		atomic.AddInt64(&m.count, -1)
@@ -131,16 +218,26 @@ func (m *MCP) Destruct(resource string) (bool, error) {
 }
 
 func (m *MCP) Construct(resource string) (bool, error) {
+	// Hosted already while constructing, since constructors may make requests.
+	m.host(resource, true)
+	constructed, err := m.construct(resource)
+	if err != nil || !constructed {
+		m.host(resource, false)
+	}
+	return constructed, err
+}
+
+func (m *MCP) construct(resource string) (bool, error) {
 	construct := &messages.Deconstruct{
 		Resource: resource,
 		Id:       fmt.Sprintf("%X", atomic.AddUint64(&nextRequestId, 1)),
 	}
 
 	flying := &flyingConstruct{
+		done:     make(chan struct{}),
 		resource: resource,
 	}
 
-	flying.waitGroup.Add(1)
 	m.flyingLock.Lock()
 	m.flyingConstructs[construct.Id] = flying
 	m.flyingLock.Unlock()
@@ -149,10 +246,26 @@ func (m *MCP) Construct(resource string) (bool, error) {
 		Type:      messages.BlobTypeConstruct,
 		Construct: construct,
 	}); err != nil {
+		m.flyingLock.Lock()
+		delete(m.flyingConstructs, construct.Id)
+		m.flyingLock.Unlock()
 		return false, err
 	}
 
-	flying.waitGroup.Wait()
+	if !wait(flying.done, m.requestTimeout) {
+		m.flyingLock.Lock()
+		_, found := m.flyingConstructs[construct.Id]
+		delete(m.flyingConstructs, construct.Id)
+		m.flyingLock.Unlock()
+		if found {
+			return false, errors.ErrTimeout
+		}
+		<-flying.done
+	}
+
+	if flying.err != nil {
+		return false, flying.err
+	}
 
 	if flying.construct.Deconstructed {
 		atomic.AddInt64(&m.count, 1)
@@ -161,13 +274,39 @@ func (m *MCP) Construct(resource string) (bool, error) {
 	return flying.construct.Deconstructed, nil
 }
 
+func errorResponse(id string, err error, code messages.ErrorCode) *messages.Response {
+	return &messages.Response{
+		Header: messages.ResponseHeader{
+			Id: id,
+			Error: &messages.Error{
+				Message: err.Error(),
+				Code:    code,
+			},
+		},
+	}
+}
+
+// SendRequest sends request to the child and waits for the response. If the child doesn't respond
+// before the timeout, or dies before responding, the returned response will contain an error
+// with code messages.ErrorCodeTimeout or messages.ErrorCodeProcessDied. Suspended children get
+// no requests, but an error with code messages.ErrorCodeSuspended right away.
 func (m *MCP) SendRequest(request *messages.Request) (*messages.Response, error) {
 	request.Header.Id = fmt.Sprintf("%X", atomic.AddUint64(&nextRequestId, 1))
 
+	// A stopped child would only let the request time out.
+	if m.Suspended() {
+		return errorResponse(request.Header.Id, errors.ErrSuspended, messages.ErrorCodeSuspended), nil
+	}
+
+	timeout := m.requestTimeout
+	if request.Header.Timeout > 0 && request.Header.Timeout < timeout {
+		timeout = request.Header.Timeout
+	}
+
 	flying := &flyingRequest{
+		done:       make(chan struct{}),
 		resourceId: request.Resource,
 	}
-	flying.waitGroup.Add(1)
 	m.flyingLock.Lock()
 	m.flyingRequests[request.Header.Id] = flying
 	m.flyingLock.Unlock()
@@ -176,14 +315,51 @@ func (m *MCP) SendRequest(request *messages.Request) (*messages.Response, error)
 		Type:    messages.BlobTypeRequest,
 		Request: request,
 	}); err != nil {
+		m.flyingLock.Lock()
+		delete(m.flyingRequests, request.Header.Id)
+		m.flyingLock.Unlock()
 		return nil, err
 	}
 
-	flying.waitGroup.Wait()
+	if !wait(flying.done, timeout) {
+		m.flyingLock.Lock()
+		_, found := m.flyingRequests[request.Header.Id]
+		delete(m.flyingRequests, request.Header.Id)
+		m.flyingLock.Unlock()
+		if found {
+			response := errorResponse(request.Header.Id, errors.ErrTimeout, messages.ErrorCodeTimeout)
+			if err := m.emit(&messages.Blob{
+				Type: messages.BlobTypeCancel,
+				Cancel: &messages.Cancel{
+					Id:    request.Header.Id,
+					Error: response.Header.Error,
+				},
+			}); err != nil {
+				m.debugHandler("MCP#SendRequest\tfailed cancelling %q: %v", request.Header.Id, err)
+			}
+			return response, nil
+		}
+		<-flying.done
+	}
 
 	return flying.response, nil
 }
 
+// ResponseError is returned by Call when the child responds with an error.
+type ResponseError struct {
+	Err *messages.Error
+}
+
+func (e *ResponseError) Error() string {
+	return e.Err.ToErr().Error()
+}
+
+// IsNoSuchMethod returns whether err is a response from a child without the called method.
+func IsNoSuchMethod(err error) bool {
+	rerr, ok := err.(*ResponseError)
+	return ok && rerr.Err.Code == messages.ErrorCodeNoSuchMethod
+}
+
 func (m *MCP) Call(source, resource, meth string, params, results interface{}) error {
 	defer m.debugHandler.Trace("MCP#Call(%q, %q, %q, %#v, %#v)", source, resource, meth, params, results)()
 	defer m.debugHandler("MCP#Call(...) => %#v", results)
@@ -210,7 +386,9 @@ func (m *MCP) Call(source, resource, meth string, params, results interface{}) e
 	}
 
 	if e := response.Header.Error; e != nil {
-		return fmt.Errorf("%v: %v", e.Message, e.Code)
+		return &ResponseError{
+			Err: e,
+		}
 	}
 
 	if results != nil {
@@ -225,7 +403,38 @@ func (m *MCP) Call(source, resource, meth string, params, results interface{}) e
 func (m *MCP) emit(blob *messages.Blob) error {
 	m.emitLock.Lock()
 	defer m.emitLock.Unlock()
-	return m.childStdinEncoder.Encode(blob)
+	m.childLock.RLock()
+	encoder := m.childStdinEncoder
+	m.childLock.RUnlock()
+	if encoder == nil {
+		return errors.ErrProcessDied
+	}
+	return encoder.Encode(blob)
+}
+
+// failFlying releases everyone waiting for the child with messages.ErrorCodeProcessDied.
+func (m *MCP) failFlying() {
+	m.flyingLock.Lock()
+	requests := m.flyingRequests
+	constructs := m.flyingConstructs
+	destructs := m.flyingDestructs
+	m.flyingRequests = map[string]*flyingRequest{}
+	m.flyingConstructs = map[string]*flyingConstruct{}
+	m.flyingDestructs = map[string]*flyingDestruct{}
+	m.flyingLock.Unlock()
+
+	for id, flying := range requests {
+		flying.response = errorResponse(id, errors.ErrProcessDied, messages.ErrorCodeProcessDied)
+		close(flying.done)
+	}
+	for _, flying := range constructs {
+		flying.err = errors.ErrProcessDied
+		close(flying.done)
+	}
+	for _, flying := range destructs {
+		flying.err = errors.ErrProcessDied
+		close(flying.done)
+	}
 }
 
 func (m *MCP) cleanup() error {
@@ -254,11 +463,16 @@ func (m *MCP) cleanup() error {
 		m.childStderr = nil
 	}
 
+	if m.child != nil && m.child.Process != nil && atomic.LoadInt32(&m.suspended) == 1 {
+		// A stopped child will never notice its stdin closing.
+		if err := m.child.Process.Kill(); err != nil {
+			m.debugHandler("MCP#cleanup\tkilling suspended child: %v", err)
+		}
+	}
+
 	if m.child != nil {
 		if m.child.Process != nil && m.child.ProcessState != nil {
-			if err := m.child.Process.Kill(); err.Error() == "os: process already finished" {
-				err = nil
-			} else if err != nil {
+			if err := m.child.Process.Kill(); err != nil && err.Error() != "os: process already finished" {
 				return err
 			}
 			if _, err := m.child.Process.Wait(); err != nil {
@@ -268,11 +482,7 @@ func (m *MCP) cleanup() error {
 		m.child = nil
 	}
 
-	m.flyingLock.Lock()
-	defer m.flyingLock.Unlock()
-	m.flyingRequests = map[string]*flyingRequest{}
-	m.flyingConstructs = map[string]*flyingConstruct{}
-	m.flyingDestructs = map[string]*flyingDestruct{}
+	m.failFlying()
 
 	return nil
 }
@@ -283,16 +493,14 @@ func (m *MCP) startProc() error {
 	m.childLock.Lock()
 	defer m.childLock.Unlock()
 
-	_, err := os.Stat(m.path)
-	if os.IsNotExist(err) {
-		if err := ioutil.WriteFile(m.path, []byte(m.code), 0444); err != nil {
-			return err
-		}
-	} else if err != nil {
+	path, err := m.buildCache.Binary(m.code)
+	if err != nil {
 		return err
 	}
 
-	m.child = exec.Command("go", "run", m.path)
+	if m.child, err = m.sandbox.command(path); err != nil {
+		return err
+	}
 	if m.childStdin, err = m.child.StdinPipe(); err != nil {
 		return err
 	}
@@ -305,51 +513,136 @@ func (m *MCP) startProc() error {
 	}
 	decoder := json.NewDecoder(m.childStdout)
 
-	m.debugHandler("MCP#startProc\tgo run %q", m.path)
+	m.debugHandler("MCP#startProc\t%q", path)
 	if err := m.child.Start(); err != nil {
 		return err
 	}
 	m.debugHandler("MCP#startProc\tstarted pid %v", m.child.Process.Pid)
+	m.started = time.Now()
+	if atomic.LoadInt32(&m.suspended) == 1 {
+		if err := m.child.Process.Signal(syscall.SIGSTOP); err != nil {
+			return err
+		}
+	}
 
 	go m.restart(m.child.Process)
-	go m.loopStdout(decoder)
+	go m.loopStdout(decoder, m.child.Process)
 	go m.loopStderr(m.childStderr)
 
 	return nil
 }
 
 func (m *MCP) restart(proc *os.Process) {
-	_, err := proc.Wait()
+	state, err := proc.Wait()
 	if err != nil {
-		log.Fatal(err)
+		m.debugHandler("MCP#restart\twaiting for child failed: %v", err)
+	} else {
+		atomic.AddInt64(&m.deadCPU, int64(state.UserTime()+state.SystemTime()))
 	}
-	m.debugHandler("MCP#restart\tchild died")
+	m.debugHandler("MCP#restart\tchild died: %v", state)
 
-	if atomic.LoadInt32(&m.stopped) == 1 {
+	m.failFlying()
+
+	m.revive(fmt.Errorf("Process died: %v", state))
+}
+
+// revive restarts the child after a delay doubling with each crash in a row, until the
+// child crash-loops. Then the MCP is broken, and only the crash loop handler gets told.
+func (m *MCP) revive(cause error) {
+	m.childLock.RLock()
+	uptime := time.Since(m.started)
+	m.childLock.RUnlock()
+	if atomic.CompareAndSwapInt32(&m.killed, 1, 0) {
+		// Killed by the server, not crashed.
+	} else if uptime < m.crashLoopUptime {
+		m.crashes++
+	} else {
+		m.crashes = 0
+	}
+	for {
+		if atomic.LoadInt32(&m.stopped) == 1 {
+			return
+		}
+
+		if m.crashes >= crashLoopThreshold {
+			m.debugHandler("MCP#restart\tcrash loop after %v crashes: %v", m.crashes, cause)
+			atomic.StoreInt32(&m.broken, 1)
+			if err := m.cleanup(); err != nil {
+				m.debugHandler("MCP#restart\tcleaning broken child failed: %v", err)
+			}
+			if m.crashLoopHandler != nil {
+				go m.crashLoopHandler(cause)
+			}
+			return
+		}
+
+		time.Sleep(m.restartDelay())
+		if atomic.LoadInt32(&m.stopped) == 1 {
+			return
+		}
+
+		if err := m.cleanup(); err != nil {
+			m.debugHandler("MCP#restart\tcleaning child failed: %v", err)
+		}
+		m.debugHandler("MCP#restart\tchild cleaned")
+
+		if err := m.startProc(); err != nil {
+			m.debugHandler("MCP#restart\tstarting child failed: %v", err)
+			m.crashes++
+			cause = err
+			continue
+		}
+		m.debugHandler("MCP#restart\tchild restarted")
 		return
 	}
+}
 
-	time.Sleep(clientRestartTimeout)
-	if err := m.cleanup(); err != nil {
-		log.Fatal(err)
+// restartDelay returns how long to wait before restarting a child after the current number of crashes in a row.
+func (m *MCP) restartDelay() time.Duration {
+	delay := m.minRestartDelay << uint(m.crashes)
+	if delay > m.maxRestartDelay {
+		return m.maxRestartDelay
 	}
-	m.debugHandler("MCP#restart\tchild cleaned")
+	return delay
+}
 
-	if err := m.startProc(); err != nil {
-		log.Fatal(err)
+// Broken returns whether the child crash-looped, in which case the MCP won't restart it again.
+func (m *MCP) Broken() bool {
+	return atomic.LoadInt32(&m.broken) == 1
+}
+
+// Unbreak forgets that the child crash-looped and starts it again, for when its code gets another chance.
+func (m *MCP) Unbreak() error {
+	if !atomic.CompareAndSwapInt32(&m.broken, 1, 0) {
+		return nil
 	}
-	m.debugHandler("MCP#restart\tchild restarted")
+	// The crash loop ended the revive loop, so nothing else touches crashes now.
+	m.crashes = 0
+	return m.Start()
 }
 
 func (m *MCP) handleRequest(request *messages.Request) {
 	defer m.debugHandler.Trace("MCP#handleRequest(%#v)", request)()
 
+	// The child chooses the source of its requests, but may only speak for the resources it hosts.
+	if !m.hosts(request.Header.Source) {
+		if err := proc.Emitter(m.emit).Error(request, &messages.Error{
+			Message: fmt.Sprintf("%v: %q", errors.ErrNotHosted, request.Header.Source),
+			Code:    messages.ErrorCodePermissionDenied,
+		}); err != nil {
+			if err := m.cleanup(); err != nil {
+				m.debugHandler("MCP#handleRequest\tcleanup failed: %v", err)
+			}
+		}
+		return
+	}
+
 	if err := proc.HandleRequest(func(blob *messages.Blob) error {
 		m.debugHandler("MCP#handleRequest for ... => %#v", blob.Response)
 		return m.emit(blob)
 	}, m.resourceFinder, request); err != nil {
 		if err := m.cleanup(); err != nil {
-			log.Fatal(err)
+			m.debugHandler("MCP#handleRequest\tcleanup failed: %v", err)
 		}
 	}
 }
@@ -368,7 +661,30 @@ func (m *MCP) constructDone(c *messages.Deconstruct) {
 	m.flyingLock.Unlock()
 	if found {
 		flying.construct = c
-		flying.waitGroup.Done()
+		close(flying.done)
+	} else if c.Deconstructed {
+		// Construct already timed out and told the caller the resource isn't here, so the child
+		// has to drop it again to keep the count right. Nobody waits for the destruct, but having
+		// it flying keeps destructDone from counting it.
+		destruct := &messages.Deconstruct{
+			Resource: c.Resource,
+			Id:       fmt.Sprintf("%X", atomic.AddUint64(&nextRequestId, 1)),
+		}
+		m.flyingLock.Lock()
+		m.flyingDestructs[destruct.Id] = &flyingDestruct{
+			done:     make(chan struct{}),
+			resource: c.Resource,
+		}
+		m.flyingLock.Unlock()
+		if err := m.emit(&messages.Blob{
+			Type:     messages.BlobTypeDestruct,
+			Destruct: destruct,
+		}); err != nil {
+			m.flyingLock.Lock()
+			delete(m.flyingDestructs, destruct.Id)
+			m.flyingLock.Unlock()
+			m.debugHandler("MCP#constructDone\tfailed destructing late %q: %v", c.Resource, err)
+		}
 	}
 }
 
@@ -379,7 +695,7 @@ func (m *MCP) handleResponse(response *messages.Response) {
 	m.flyingLock.Unlock()
 	if found {
 		flying.response = response
-		flying.waitGroup.Done()
+		close(flying.done)
 	}
 }
 
@@ -390,11 +706,15 @@ func (m *MCP) destructDone(d *messages.Deconstruct) {
 	m.flyingLock.Unlock()
 	if found {
 		flying.destruct = d
-		flying.waitGroup.Done()
+		close(flying.done)
+	} else if d.Deconstructed {
+		// Destruct timed out before the child got around to it.
+		m.host(d.Resource, false)
+		atomic.AddInt64(&m.count, -1)
 	}
 }
 
-func (m *MCP) loopStdout(dec *json.Decoder) {
+func (m *MCP) loopStdout(dec *json.Decoder, proc *os.Process) {
 	for {
 		blob := &messages.Blob{}
 		err := dec.Decode(blob)
@@ -402,10 +722,12 @@ func (m *MCP) loopStdout(dec *json.Decoder) {
 			m.debugHandler("EOF from STDIN")
 			return
 		} else if err != nil {
+			// Either the pipe is closed or the child is talking nonsense, so kill it and let it be restarted.
 			m.debugHandler("Decoding JSON from child STDIN: %v", err)
-			if err := m.Stop(); err != nil {
-				log.Fatal(err)
+			if err := proc.Kill(); err != nil {
+				m.debugHandler("Killing child: %v", err)
 			}
+			return
 		}
 		switch blob.Type {
 		case messages.BlobTypeRequest:
@@ -418,9 +740,10 @@ func (m *MCP) loopStdout(dec *json.Decoder) {
 			go m.destructDone(blob.Destruct)
 		default:
 			m.debugHandler("Unknown blob type %v", blob.Type)
-			if err := m.Stop(); err != nil {
-				log.Fatal(err)
+			if err := proc.Kill(); err != nil {
+				m.debugHandler("Killing child: %v", err)
 			}
+			return
 		}
 	}
 }
@@ -434,7 +757,7 @@ func (m *MCP) loopStderr(r io.ReadCloser) {
 			return
 		} else if err != nil {
 			m.debugHandler("Reading from child STDERR: %v", err)
-			log.Fatal(err)
+			return
 		}
 		m.stderrHandler(buf[:r])
 	}
@@ -442,7 +765,7 @@ func (m *MCP) loopStderr(r io.ReadCloser) {
 
 func (m *MCP) Start() error {
 	if atomic.LoadInt32(&m.stopped) == 1 {
-		return errors.New("Already stopped")
+		return errors.ErrAlreadyStopped
 	}
 	if err := m.cleanup(); err != nil {
 		return err
@@ -0,0 +1,354 @@
+package mcp
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zond/hackyhack/proc/build"
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+// stubChild talks the child protocol without the slave package, to misbehave in ways real children
+// only do by accident. It constructs "slow" late, never answers "Hang", dies on "Die", and tells
+// stderr about destructs and cancels.
+const stubChild = `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+func main() {
+	lock := sync.Mutex{}
+	encoder := json.NewEncoder(os.Stdout)
+	emit := func(blob *messages.Blob) {
+		lock.Lock()
+		defer lock.Unlock()
+		encoder.Encode(blob)
+	}
+	decoder := json.NewDecoder(os.Stdin)
+	for {
+		blob := &messages.Blob{}
+		if err := decoder.Decode(blob); err != nil {
+			os.Exit(0)
+		}
+		switch blob.Type {
+		case messages.BlobTypeConstruct:
+			go func(c *messages.Deconstruct) {
+				if c.Resource == "slow" {
+					time.Sleep(500 * time.Millisecond)
+				}
+				c.Deconstructed = true
+				emit(&messages.Blob{Type: messages.BlobTypeConstruct, Construct: c})
+			}(blob.Construct)
+		case messages.BlobTypeDestruct:
+			fmt.Fprintf(os.Stderr, "destruct %v\n", blob.Destruct.Resource)
+			blob.Destruct.Deconstructed = true
+			emit(blob)
+		case messages.BlobTypeCancel:
+			fmt.Fprintf(os.Stderr, "cancel %v\n", blob.Cancel.Id)
+		case messages.BlobTypeRequest:
+			switch blob.Request.Method {
+			case "Hang":
+			case "Die":
+				os.Exit(1)
+			default:
+				emit(&messages.Blob{
+					Type: messages.BlobTypeResponse,
+					Response: &messages.Response{
+						Header: messages.ResponseHeader{Id: blob.Request.Header.Id},
+						Result: "[]",
+					},
+				})
+			}
+		}
+	}
+}
+`
+
+// stderrLog collects what a child writes to stderr.
+type stderrLog struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+func (s *stderrLog) write(b []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buf = append(s.buf, b...)
+}
+
+// await waits for the child to write line.
+func (s *stderrLog) await(t *testing.T, line string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		found := strings.Contains(string(s.buf), line+"\n")
+		s.lock.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Child never wrote %q", line)
+}
+
+// crasher dies right after starting.
+const crasher = `package main
+
+import "os"
+
+func main() {
+	os.Exit(1)
+}
+`
+
+// newStub returns an unstarted MCP running code, and what the child writes to stderr.
+func newStub(t *testing.T, code string) (*MCP, *stderrLog) {
+	dir, err := ioutil.TempDir("", "mcp_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	cache, err := build.NewCache(dir, build.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(code, cache, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := &stderrLog{}
+	m.StderrHandler(stderr.write)
+	m.debugHandler = func(string, ...interface{}) {}
+	t.Cleanup(func() {
+		m.Stop()
+	})
+	return m, stderr
+}
+
+func startStub(t *testing.T, timeout time.Duration) (*MCP, *stderrLog) {
+	m, stderr := newStub(t, stubChild)
+	m.RequestTimeout(timeout)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return m, stderr
+}
+
+// crashLoops returns a channel getting the cause when m crash-loops.
+func crashLoops(m *MCP) chan error {
+	causes := make(chan error, 1)
+	m.CrashLoopHandler(func(cause error) {
+		causes <- cause
+	})
+	return causes
+}
+
+func awaitCrashLoop(t *testing.T, causes chan error) error {
+	select {
+	case cause := <-causes:
+		return cause
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Child never crash-looped")
+	}
+	return nil
+}
+
+func TestRequestTimeout(t *testing.T) {
+	m, stderr := startStub(t, 100*time.Millisecond)
+	request := &messages.Request{
+		Resource: "a",
+		Method:   "Hang",
+	}
+	resp, err := m.SendRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Error == nil || resp.Header.Error.Code != messages.ErrorCodeTimeout {
+		t.Errorf("Got %+v, wanted a timeout", resp.Header.Error)
+	}
+	stderr.await(t, "cancel "+request.Header.Id)
+
+	// The MCP keeps working after a timeout.
+	if err := m.Call("a", "a", "Echo", nil, nil); err != nil {
+		t.Errorf("Got %v, wanted no error", err)
+	}
+}
+
+func TestProcessDiedDuringRequest(t *testing.T) {
+	m, _ := startStub(t, time.Minute)
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Call("a", "a", "Die", nil, nil)
+	}()
+	select {
+	case err := <-done:
+		rerr, ok := err.(*ResponseError)
+		if !ok || rerr.Err.Code != messages.ErrorCodeProcessDied {
+			t.Errorf("Got %v, wanted process died", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Request still waiting for a dead child")
+	}
+}
+
+func TestConstructTimeout(t *testing.T) {
+	m, stderr := startStub(t, 100*time.Millisecond)
+	if _, err := m.Construct("slow"); err == nil {
+		t.Fatalf("Wanted slow construct to time out")
+	}
+	// The late construct gets undone, since the caller thinks it failed.
+	stderr.await(t, "destruct slow")
+	if constructed, err := m.Construct("fast"); err != nil || !constructed {
+		t.Fatalf("Got %v, %v, wanted fast construct", constructed, err)
+	}
+	if count := m.Count(); count != 1 {
+		t.Errorf("Got count %v, wanted 1", count)
+	}
+	if destructed, err := m.Destruct("fast"); err != nil || !destructed {
+		t.Fatalf("Got %v, %v, wanted fast destruct", destructed, err)
+	}
+	if count := m.Count(); count != 0 {
+		t.Errorf("Got count %v, wanted 0", count)
+	}
+}
+
+func TestSuspendedRequest(t *testing.T) {
+	m, _ := startStub(t, time.Minute)
+	if err := m.Suspend(true); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err := m.Call("a", "a", "Echo", nil, nil)
+	if rerr, ok := err.(*ResponseError); !ok || rerr.Err.Code != messages.ErrorCodeSuspended {
+		t.Errorf("Got %v, wanted suspended", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Took %v to refuse, wanted no waiting for the stopped child", elapsed)
+	}
+	if err := m.Suspend(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Call("a", "a", "Echo", nil, nil); err != nil {
+		t.Errorf("Got %v, wanted no error after continuing", err)
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	m := &MCP{
+		minRestartDelay: time.Second,
+		maxRestartDelay: time.Minute,
+	}
+	for crashes, want := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		32 * time.Second,
+		time.Minute,
+		time.Minute,
+	} {
+		m.crashes = crashes
+		if got := m.restartDelay(); got != want {
+			t.Errorf("Got %v after %v crashes, wanted %v", got, crashes, want)
+		}
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	m, _ := newStub(t, crasher)
+	m.minRestartDelay = 10 * time.Millisecond
+	m.maxRestartDelay = 40 * time.Millisecond
+	causes := crashLoops(m)
+	start := time.Now()
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	awaitCrashLoop(t, causes)
+	if !m.Broken() {
+		t.Errorf("Wanted a crash-looping child to break the MCP")
+	}
+	// Restarted after 20ms, 40ms, 40ms and 40ms before the fifth crash in a row.
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("Crash-looped after %v, wanted backing off for at least 140ms", elapsed)
+	}
+	if err := m.Call("a", "a", "Echo", nil, nil); err == nil {
+		t.Errorf("Wanted calls to a broken MCP to fail")
+	}
+}
+
+func TestStartFailureCountsAsCrash(t *testing.T) {
+	m, _ := newStub(t, stubChild)
+	m.minRestartDelay = 10 * time.Millisecond
+	m.maxRestartDelay = 10 * time.Millisecond
+	// Every death looks like it happened long after starting.
+	m.crashLoopUptime = 0
+	causes := crashLoops(m)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Replace the binary, so that the child can't be started again.
+	path, err := m.buildCache.Binary(m.code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("not a binary"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Call("a", "a", "Die", nil, nil); err == nil {
+		t.Errorf("Wanted the dying child to fail the request")
+	}
+	if cause := awaitCrashLoop(t, causes); cause == nil {
+		t.Errorf("Wanted the start failure as cause")
+	}
+	if !m.Broken() {
+		t.Errorf("Wanted failing to start to break the MCP")
+	}
+}
+
+func TestUnbreak(t *testing.T) {
+	m, _ := newStub(t, stubChild)
+	m.minRestartDelay = 10 * time.Millisecond
+	m.maxRestartDelay = 10 * time.Millisecond
+	causes := crashLoops(m)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Kill the child until it crash-loops.
+	deadline := time.Now().Add(10 * time.Second)
+	for !m.Broken() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Child never crash-looped")
+		}
+		m.Call("a", "a", "Die", nil, nil)
+		select {
+		case <-causes:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if err := m.Unbreak(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Broken() {
+		t.Errorf("Wanted the MCP no longer broken")
+	}
+	if err := m.Call("a", "a", "Echo", nil, nil); err != nil {
+		t.Errorf("Got %v, wanted the child running again", err)
+	}
+}
@@ -0,0 +1,35 @@
+package mcp
+
+// Sandbox configures how children are isolated from the server. Since it is applied by the server
+// before the child code runs, the child can't opt out of it. The zero value runs children unsandboxed.
+type Sandbox struct {
+	// Namespaces runs children in their own user, mount, network, PID, IPC and UTS namespaces,
+	// mapped to root inside but to the server user outside.
+	Namespaces bool
+	// ReadOnlyRoot remounts every filesystem read-only, and gives children a /proc of their own.
+	// Requires Namespaces.
+	ReadOnlyRoot bool
+	// Hidden are directories, like the database and the build cache, that children see empty
+	// instead. /home, /root, $HOME and $GOPATH are always hidden. Requires ReadOnlyRoot.
+	Hidden []string
+	// Seccomp denies children syscalls that reach outside their own process, like ptrace, mount
+	// or socket.
+	Seccomp bool
+}
+
+// DefaultSandbox is everything turned on, hiding nothing but the always hidden directories.
+var DefaultSandbox = &Sandbox{
+	Namespaces:   true,
+	ReadOnlyRoot: true,
+	Seccomp:      true,
+}
+
+func (s *Sandbox) enabled() bool {
+	return s != nil && (s.Namespaces || s.ReadOnlyRoot || s.Seccomp)
+}
+
+// Sandbox sets how to isolate the children of this MCP.
+func (m *MCP) Sandbox(s *Sandbox) *MCP {
+	m.sandbox = s
+	return m
+}
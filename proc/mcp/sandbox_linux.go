@@ -0,0 +1,273 @@
+//go:build linux
+
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sandboxArg0 is the argv[0] the server re-executes itself with to set up the sandbox of a child.
+	sandboxArg0 = "hackyhack-sandbox"
+	// sandboxEnv carries the JSON encoded Sandbox to the launcher.
+	sandboxEnv = "HACKYHACK_SANDBOX"
+)
+
+// command returns a command running path inside the sandbox.
+func (s *Sandbox) command(path string, args ...string) (*exec.Cmd, error) {
+	if !s.enabled() {
+		return exec.Command(path, args...), nil
+	}
+	return s.launcher(append([]string{path}, args...))
+}
+
+// Check sets up the sandbox without running anything in it, to find out if the machine allows it.
+func (s *Sandbox) Check() error {
+	if !s.enabled() {
+		return nil
+	}
+	cmd, err := s.launcher(nil)
+	if err != nil {
+		return err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// launcher returns a command re-executing the server binary to set up the sandbox and exec args.
+func (s *Sandbox) launcher(args []string) (*exec.Cmd, error) {
+	if s.ReadOnlyRoot && !s.Namespaces {
+		return nil, fmt.Errorf("A read-only root requires namespaces")
+	}
+	if len(s.Hidden) > 0 && !s.ReadOnlyRoot {
+		return nil, fmt.Errorf("Hiding directories requires a read-only root")
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args = append([]string{sandboxArg0}, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%v=%s", sandboxEnv, b))
+	if s.Namespaces {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET |
+				syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+			UidMappings: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+			},
+			GidMappings: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+			},
+			GidMappingsEnableSetgroups: false,
+		}
+	}
+	return cmd, nil
+}
+
+func init() {
+	if len(os.Args) < 1 || os.Args[0] != sandboxArg0 {
+		return
+	}
+	if err := launch(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Sandboxing failed: %v\n", err)
+		os.Exit(1)
+	}
+	// Nothing to exec, since Sandbox#Check only wanted to know if this far was possible.
+	os.Exit(0)
+}
+
+// launch runs in the server binary re-executed by Sandbox#launcher, already inside the new namespaces,
+// and execs args, if any, after locking itself in.
+func launch(args []string) error {
+	// Capabilities, no_new_privs and seccomp filters are per thread, and exec keeps only the calling thread.
+	runtime.LockOSThread()
+
+	s := &Sandbox{}
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxEnv)), s); err != nil {
+		return fmt.Errorf("Unparseable %v: %v", sandboxEnv, err)
+	}
+	// Children get no secrets from the environment of the server.
+	env := []string{"PATH=" + os.Getenv("PATH"), "HOME=/"}
+
+	// The binary may be in a hidden directory, so open it before hiding and exec the open file.
+	exe := -1
+	if len(args) > 0 {
+		fd, err := unix.Open(args[0], unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("Opening %q: %v", args[0], err)
+		}
+		exe = fd
+	}
+
+	if s.ReadOnlyRoot {
+		if err := readOnlyRoot(hidden(s.Hidden)); err != nil {
+			return err
+		}
+	}
+	if s.Namespaces {
+		if err := dropCapabilities(); err != nil {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("Setting no_new_privs: %v", err)
+	}
+	if s.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return err
+		}
+	}
+	if exe == -1 {
+		return nil
+	}
+	return execFd(exe, args, env)
+}
+
+// hidden returns the directories to hide from children, the configured ones and those where the
+// server user keeps things.
+func hidden(configured []string) []string {
+	result := append([]string{"/home", "/root"}, configured...)
+	if home := os.Getenv("HOME"); home != "" {
+		result = append(result, home)
+	}
+	return append(result, filepath.SplitList(os.Getenv("GOPATH"))...)
+}
+
+// execFd execs the file open as fd, like unix.Exec execs a path.
+func execFd(fd int, argv, envv []string) error {
+	argvp, err := syscall.SlicePtrFromStrings(argv)
+	if err != nil {
+		return err
+	}
+	envvp, err := syscall.SlicePtrFromStrings(envv)
+	if err != nil {
+		return err
+	}
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_EXECVEAT, uintptr(fd), uintptr(unsafe.Pointer(empty)),
+		uintptr(unsafe.Pointer(&argvp[0])), uintptr(unsafe.Pointer(&envvp[0])), unix.AT_EMPTY_PATH, 0)
+	return fmt.Errorf("Executing %q: %v", argv[0], errno)
+}
+
+// readOnlyRoot remounts every mount in the new mount namespace read-only, covers the hidden
+// directories with empty ones, and replaces /proc with one only showing the new PID namespace.
+func readOnlyRoot(hidden []string) error {
+	// Keep the remounts from propagating back to the mount namespace of the server.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("Making mounts private: %v", err)
+	}
+	mountPoints, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if err := remountReadOnly(mountPoint); err != nil {
+			// Mount points can be hidden below other mounts, or be special kernel filesystems that
+			// refuse remounts, but / has to work.
+			if mountPoint == "/" {
+				return err
+			}
+		}
+	}
+	for _, dir := range hidden {
+		if err := hide(dir); err != nil {
+			return err
+		}
+	}
+	procFlags := uintptr(unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	if err := unix.Mount("proc", "/proc", "proc", procFlags, ""); err != nil {
+		// Without a fully visible /proc, like in most containers, we may not mount a new one. Then
+		// hide the one of the server instead.
+		if err := unix.Mount("tmpfs", "/proc", "tmpfs", procFlags|unix.MS_RDONLY, "size=0"); err != nil {
+			return fmt.Errorf("Hiding /proc: %v", err)
+		}
+	}
+	return nil
+}
+
+// hide mounts an empty read-only tmpfs over dir, if dir is a directory.
+func hide(dir string) error {
+	if dir == "" || filepath.Clean(dir) == "/" {
+		return nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		// Nothing to see there anyway.
+		return nil
+	}
+	flags := uintptr(unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_RDONLY)
+	if err := unix.Mount("tmpfs", dir, "tmpfs", flags, "size=0"); err != nil {
+		return fmt.Errorf("Hiding %q: %v", dir, err)
+	}
+	return nil
+}
+
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// Spaces and such are octal escaped in /proc/self/mounts.
+		mountPoint := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(fields[1])
+		result = append(result, mountPoint)
+	}
+	return result, scanner.Err()
+}
+
+// lockedMountFlags are the flags that, once set by a more privileged user namespace, have to be kept
+// when remounting.
+const lockedMountFlags = unix.ST_NOSUID | unix.ST_NODEV | unix.ST_NOEXEC | unix.ST_NOATIME | unix.ST_NODIRATIME | unix.ST_RELATIME
+
+func remountReadOnly(mountPoint string) error {
+	st := &unix.Statfs_t{}
+	if err := unix.Statfs(mountPoint, st); err != nil {
+		return fmt.Errorf("Statfs %q: %v", mountPoint, err)
+	}
+	// The ST_ flags have the same values as the MS_ flags.
+	flags := uintptr(unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY) | uintptr(st.Flags&lockedMountFlags)
+	if err := unix.Mount("", mountPoint, "", flags, ""); err != nil {
+		return fmt.Errorf("Remounting %q read-only: %v", mountPoint, err)
+	}
+	return nil
+}
+
+// dropCapabilities empties the bounding set, so that the child, running as root in the new user
+// namespace, gets no capabilities when exec'd.
+func dropCapabilities() error {
+	for capability := 0; ; capability++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0); err == unix.EINVAL {
+			// Past the last capability the kernel knows about.
+			if capability == 0 {
+				return fmt.Errorf("Dropping capabilities: %v", err)
+			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Dropping capability %v: %v", capability, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package mcp
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// errNoSandbox is returned when asking for a sandbox where namespaces and seccomp don't exist.
+var errNoSandbox = fmt.Errorf("Sandboxing requires Linux")
+
+// command returns a command running path, unless a sandbox is wanted.
+func (s *Sandbox) command(path string, args ...string) (*exec.Cmd, error) {
+	if s.enabled() {
+		return nil, errNoSandbox
+	}
+	return exec.Command(path, args...), nil
+}
+
+// Check returns an error if a sandbox is wanted, since there is none outside Linux.
+func (s *Sandbox) Check() error {
+	if s.enabled() {
+		return errNoSandbox
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sandboxed runs script with sh in s, skipping the test if the machine doesn't allow
+// unprivileged user namespaces.
+func sandboxed(t *testing.T, s *Sandbox, script string) (string, error) {
+	cmd, err := s.command("/bin/sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil && cmd.ProcessState == nil {
+		t.Skipf("Unable to start sandbox: %v", err)
+	}
+	if strings.Contains(string(out), "Sandboxing failed") {
+		t.Skipf("Unable to set up sandbox: %s", out)
+	}
+	return string(out), err
+}
+
+func TestSandbox(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sandbox-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "written")
+
+	out, err := sandboxed(t, DefaultSandbox, "echo $$; echo x > "+target+" || echo unwritable")
+	if err != nil {
+		t.Fatalf("Got %v: %s", err, out)
+	}
+	lines := strings.Fields(out)
+	if len(lines) == 0 || lines[0] != "1" {
+		t.Errorf("Wanted the sandboxed shell to be pid 1, got %q", out)
+	}
+	if !strings.Contains(out, "unwritable") {
+		t.Errorf("Wanted the sandboxed shell unable to write, got %q", out)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Wanted no %q, got %v", target, err)
+	}
+}
+
+func TestSandboxHides(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sandbox-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "hackyhack.db")
+	if err := os.WriteFile(db, []byte("database contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HACKYHACK_SECRET", "environment contents")
+
+	s := *DefaultSandbox
+	s.Hidden = []string{dir}
+	out, err := sandboxed(t, &s, "cat "+db+" || echo unreadable; echo home=$HOME secret=$HACKYHACK_SECRET")
+	if err != nil {
+		t.Fatalf("Got %v: %s", err, out)
+	}
+	if strings.Contains(out, "database contents") || !strings.Contains(out, "unreadable") {
+		t.Errorf("Wanted the sandboxed shell unable to open the database, got %q", out)
+	}
+	if !strings.HasSuffix(out, "home=/ secret=\n") {
+		t.Errorf("Wanted only allowed environment in the sandbox, got %q", out)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var unsandboxed *Sandbox
+	if err := unsandboxed.Check(); err != nil {
+		t.Errorf("Got %v, wanted no sandbox to always work", err)
+	}
+	if err := (&Sandbox{ReadOnlyRoot: true}).Check(); err == nil {
+		t.Errorf("Wanted a read-only root without namespaces to fail")
+	}
+	if err := (&Sandbox{Namespaces: true, Hidden: []string{"/tmp"}}).Check(); err == nil {
+		t.Errorf("Wanted hiding without a read-only root to fail")
+	}
+	if err := DefaultSandbox.Check(); err != nil {
+		t.Skipf("Unable to set up sandbox: %v", err)
+	}
+	if out, err := sandboxed(t, DefaultSandbox, "true"); err != nil {
+		t.Errorf("Got %v: %s, wanted the checked sandbox to work", err, out)
+	}
+}
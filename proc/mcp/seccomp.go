@@ -0,0 +1,112 @@
+//go:build linux
+
+package mcp
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Offsets into struct seccomp_data.
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	// x32 syscalls on amd64 have this bit set, and would otherwise dodge the denylist.
+	x32SyscallBit = 0x40000000
+)
+
+// deniedSyscalls are the syscalls, on top of archDeniedSyscalls, that children have no business making.
+// The Go runtime needs none of them, and the validator already keeps child code from reaching for
+// the packages that would.
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PIDFD_GETFD,
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_MOUNT_SETATTR,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSPICK,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_SOCKET,
+	unix.SYS_SOCKETPAIR,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_FANOTIFY_INIT,
+	unix.SYS_IO_URING_SETUP,
+	unix.SYS_IO_URING_ENTER,
+	unix.SYS_IO_URING_REGISTER,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_REBOOT,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_ACCT,
+	unix.SYS_QUOTACTL,
+	unix.SYS_LOOKUP_DCOOKIE,
+	unix.SYS_SYSLOG,
+	unix.SYS_VHANGUP,
+	unix.SYS_PERSONALITY,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_SETHOSTNAME,
+	unix.SYS_SETDOMAINNAME,
+}
+
+// seccompFilter returns a BPF program returning EPERM for the denied syscalls, and killing the
+// process for syscalls made using another architecture than the one the denylist is for.
+func seccompFilter() []unix.SockFilter {
+	deny := unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)}
+	kill := unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_KILL_PROCESS}
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, Jf: 0, K: seccompArch},
+		kill,
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataNr},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: 0, Jf: 1, K: x32SyscallBit},
+		kill,
+	}
+	for _, nr := range append(append([]uint32{}, deniedSyscalls...), archDeniedSyscalls...) {
+		filter = append(filter,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 1, K: nr},
+			deny)
+	}
+	return append(filter, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW})
+}
+
+// installSeccomp installs the seccomp filter for the calling thread, which will be kept across exec.
+// Requires no_new_privs.
+func installSeccomp() error {
+	if seccompArch == 0 {
+		return fmt.Errorf("No seccomp filter for this architecture")
+	}
+	filter := seccompFilter()
+	prog := &unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(prog)), 0, 0); err != nil {
+		return fmt.Errorf("Installing seccomp filter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package mcp
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seccompArch = unix.AUDIT_ARCH_X86_64
+
+var archDeniedSyscalls = []uint32{
+	unix.SYS_IOPL,
+	unix.SYS_IOPERM,
+	unix.SYS_MODIFY_LDT,
+	unix.SYS_USELIB,
+	unix.SYS__SYSCTL,
+	unix.SYS_KEXEC_FILE_LOAD,
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package mcp
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seccompArch = unix.AUDIT_ARCH_AARCH64
+
+var archDeniedSyscalls = []uint32{
+	unix.SYS_KEXEC_FILE_LOAD,
+}
@@ -0,0 +1,8 @@
+//go:build linux && !amd64 && !arm64
+
+package mcp
+
+// seccompArch is zero where there is no seccomp filter, making installSeccomp fail.
+const seccompArch = 0
+
+var archDeniedSyscalls = []uint32{}
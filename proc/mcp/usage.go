@@ -0,0 +1,95 @@
+package mcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// clockTicks is the USER_HZ unit of the times in /proc/[pid]/stat.
+	clockTicks = 100
+)
+
+// procUsage reads the CPU time and resident set size of pid from /proc.
+func procUsage(pid int) (time.Duration, int64, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name may contain spaces, so start after its closing paren.
+	stat := string(b)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	// fields[0] is field 3 (state) in proc(5), so utime (14), stime (15) and rss (24) are offset by 3.
+	if len(fields) < 22 {
+		return 0, 0, fmt.Errorf("Unparseable /proc/%d/stat: %q", pid, stat)
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Duration(utime+stime) * time.Second / clockTicks, rss * int64(os.Getpagesize()), nil
+}
+
+// Usage returns the CPU time used by all children this MCP has run, and the current
+// resident set size of the running child.
+func (m *MCP) Usage() (time.Duration, int64) {
+	m.childLock.RLock()
+	defer m.childLock.RUnlock()
+	cpu := time.Duration(atomic.LoadInt64(&m.deadCPU))
+	if m.child == nil || m.child.Process == nil {
+		return cpu, 0
+	}
+	childCPU, rss, err := procUsage(m.child.Process.Pid)
+	if err != nil {
+		// The child is probably dead, and its CPU time is already in deadCPU.
+		return cpu, 0
+	}
+	return cpu + childCPU, rss
+}
+
+// Suspend stops or continues the running child, and any child started while suspended.
+func (m *MCP) Suspend(suspended bool) error {
+	sig := syscall.SIGCONT
+	if suspended {
+		atomic.StoreInt32(&m.suspended, 1)
+		sig = syscall.SIGSTOP
+	} else {
+		atomic.StoreInt32(&m.suspended, 0)
+	}
+	m.childLock.RLock()
+	defer m.childLock.RUnlock()
+	if m.child == nil || m.child.Process == nil {
+		return nil
+	}
+	return m.child.Process.Signal(sig)
+}
+
+func (m *MCP) Suspended() bool {
+	return atomic.LoadInt32(&m.suspended) == 1
+}
+
+// Kill kills the running child, which will then be restarted. Since the code didn't crash by
+// itself, the death doesn't count towards a crash loop.
+func (m *MCP) Kill() error {
+	m.childLock.RLock()
+	defer m.childLock.RUnlock()
+	if m.child == nil || m.child.Process == nil {
+		return nil
+	}
+	atomic.StoreInt32(&m.killed, 1)
+	return m.child.Process.Kill()
+}
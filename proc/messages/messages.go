@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/gedex/inflector"
 	"github.com/zond/hackyhack/lang"
@@ -15,6 +16,17 @@ const (
 
 type Context struct {
 	Request *Request
+	// Done is closed if the requester stops waiting for the response.
+	Done <-chan struct{} `json:"-"`
+}
+
+func (c *Context) Cancelled() bool {
+	select {
+	case <-c.Done:
+		return true
+	default:
+		return false
+	}
 }
 
 type EventType int
@@ -24,10 +36,44 @@ const (
 	EventTypeConstruct
 	EventTypeDestruct
 	EventTypeSay
+	EventTypeLeave
+	EventTypeArrive
+	EventTypeTake
+	EventTypeDrop
+	EventTypeGive
+	EventTypeChallenge
+	EventTypeHeartbeat
+	EventTypeBroken
 )
 
+var eventTypeNames = map[EventType]string{
+	EventTypeRequest:   "Request",
+	EventTypeConstruct: "Construct",
+	EventTypeDestruct:  "Destruct",
+	EventTypeSay:       "Say",
+	EventTypeLeave:     "Leave",
+	EventTypeArrive:    "Arrive",
+	EventTypeTake:      "Take",
+	EventTypeDrop:      "Drop",
+	EventTypeGive:      "Give",
+	EventTypeChallenge: "Challenge",
+	EventTypeHeartbeat: "Heartbeat",
+	EventTypeBroken:    "Broken",
+}
+
+// String returns the name of the event type, which is what Subscription.EventTypeReg matches.
+func (e EventType) String() string {
+	if name, found := eventTypeNames[e]; found {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(e))
+}
+
 const (
 	MetadataPayload = "Payload"
+	MetadataExit    = "Exit"
+	MetadataPwned   = "Pwned"
+	MetadataTime    = "Time"
 )
 
 const (
@@ -37,7 +83,33 @@ const (
 	MethodGetShortDesc = "GetShortDesc"
 	MethodGetLongDesc  = "GetLongDesc"
 	MethodSubscribe    = "Subscribe"
+	MethodUnsubscribe  = "Unsubscribe"
+	MethodGetSubs      = "GetSubscriptions"
 	MethodEmitEvent    = "EmitEvent"
+	MethodGetQuota     = "GetQuota"
+	MethodCreate       = "Create"
+	MethodGetExits     = "GetExits"
+	MethodMove         = "Move"
+	MethodAllowMove    = "AllowMove"
+	MethodTake         = "Take"
+	MethodDrop         = "Drop"
+	MethodGive         = "Give"
+	MethodSetPerms     = "SetPermissions"
+	MethodTransform    = "Transform"
+	MethodChallenge    = "Challenge"
+	MethodSchedule     = "Schedule"
+	MethodUnschedule   = "Unschedule"
+	MethodGetTimers    = "GetTimers"
+	MethodGetState     = "GetState"
+	MethodSetState     = "SetState"
+	MethodDeleteState  = "DeleteState"
+	MethodListState    = "ListState"
+	MethodLog          = "Log"
+	MethodGetLogs      = "GetLogs"
+	MethodChangePass   = "ChangePassword"
+	MethodWebAuth      = "WebAuth"
+	MethodGetTerminal  = "GetTerminal"
+	MethodSendGMCP     = "SendGMCP"
 )
 
 type BlobType int
@@ -47,6 +119,7 @@ const (
 	BlobTypeResponse
 	BlobTypeConstruct
 	BlobTypeDestruct
+	BlobTypeCancel
 )
 
 type ErrorCode int
@@ -65,6 +138,38 @@ const (
 	ErrorCodeDatabase
 	ErrorCodeRegexp
 	ErrorCodeEventType
+	ErrorCodeTimeout
+	ErrorCodeProcessDied
+	ErrorCodeValidation
+	ErrorCodeBuild
+	ErrorCodeNotOwner
+	ErrorCodeNotAdjacent
+	ErrorCodeRefused
+	ErrorCodePermissionDenied
+	ErrorCodeNoSuchSubscription
+	ErrorCodeInvalidTimer
+	ErrorCodeNoSuchTimer
+	ErrorCodeNoSuchKey
+	ErrorCodeQuotaExceeded
+	ErrorCodeNoClient
+	ErrorCodeSuspended
+)
+
+// Permission decides what other resources may call a method of a resource.
+type Permission int
+
+const (
+	// PermissionPublic lets any adjacent resource call the method.
+	PermissionPublic Permission = iota
+	// PermissionOwner only lets resources with the same owner call the method.
+	PermissionOwner
+	// PermissionContainer only lets the container of the resource, or resources with the same owner, call the method.
+	PermissionContainer
+)
+
+const (
+	// PermissionsDefault is the method name whose permission applies to methods without their own.
+	PermissionsDefault = "*"
 )
 
 type Error struct {
@@ -150,19 +255,107 @@ func (sd *ShortDesc) Pluralize() string {
 	return inflector.Pluralize(sd.Value)
 }
 
+// Create describes a new resource. Either Code or CodeFrom, the id of a resource with
+// the same owner whose code will be reused, must be set.
+type Create struct {
+	Code     string
+	CodeFrom string
+	// InContainer creates the resource in the container of the creator instead of in the creator.
+	InContainer bool
+}
+
+// Quota describes how much of the budget of the owner of a resource is used.
+type Quota struct {
+	CPU          time.Duration
+	CPUQuota     time.Duration
+	RSS          int64
+	RSSQuota     int64
+	Storage      int64
+	StorageQuota int64
+	WindowEnd    time.Time
+	Suspended    bool
+}
+
+// String describes the quota to players.
+func (q *Quota) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "CPU: %v of %v until %v\nMemory: %vkB of %vkB\n", q.CPU, q.CPUQuota, q.WindowEnd.Format("15:04:05"), q.RSS>>10, q.RSSQuota>>10)
+	fmt.Fprintf(buf, "Storage: %vkB of %vkB\n", q.Storage>>10, q.StorageQuota>>10)
+	if q.Suspended {
+		fmt.Fprint(buf, "Your objects are suspended until the CPU budget is refilled.\n")
+	}
+	return buf.String()
+}
+
+// Terminal describes the terminal of the client controlling a resource, as told by the client.
+// Zero values mean it didn't say.
+type Terminal struct {
+	Type   string
+	Width  int
+	Height int
+	// GMCP is whether the client accepts out-of-band data sent with SendGMCP.
+	GMCP bool
+}
+
 type Subscription struct {
+	Id           string
 	VerbReg      string
 	MethReg      string
 	EventTypeReg string
 	HandlerName  string
 }
 
+// LogLine is a line written by Resource to stderr, or logged via the router.
+type LogLine struct {
+	Time     time.Time
+	Resource string
+	Source   string
+	Text     string
+}
+
+// Timer calls Method of the scheduling resource, with the Timer as parameter, At, and then every Interval
+// if Interval is set. A zero At means one Interval from now.
+type Timer struct {
+	Id       string
+	Method   string
+	At       time.Time
+	Interval time.Duration
+}
+
+// Transfer describes Source moving Resource from From to To.
+type Transfer struct {
+	Resource string
+	Source   string
+	From     string
+	To       string
+}
+
 type Event struct {
 	Type            EventType
 	Source          string
 	SourceShortDesc *ShortDesc
+	// Object and Target are what and to whom something was done, if anything.
+	Object          string
+	ObjectShortDesc *ShortDesc
+	Target          string
+	TargetShortDesc *ShortDesc
 	Metadata        map[string]string
 	Request         *Request
+	// Propagation, if set, makes the event reach further than the content of the container it happens in.
+	Propagation *Propagation `json:",omitempty"`
+	// Attenuation is how many containers or exits away from where it happened the event was heard.
+	Attenuation int
+}
+
+// Propagation decides how far beyond the content of its container an event travels.
+// The router limits all of them.
+type Propagation struct {
+	// Depth is how many levels of content inside the content of each reached container hear the event.
+	Depth int
+	// Up is how many containers outside the container hear the event.
+	Up int
+	// Exits is how many exits away the event is heard.
+	Exits int
 }
 
 type Verb struct {
@@ -179,6 +372,8 @@ type RequestHeader struct {
 	Id     string
 	Source string
 	Verb   *Verb
+	// Timeout, if set, shortens the time the receiving MCP waits for a response.
+	Timeout time.Duration `json:",omitempty"`
 }
 
 type Request struct {
@@ -204,10 +399,17 @@ type Deconstruct struct {
 	Deconstructed bool
 }
 
+// Cancel tells the receiver that the sender no longer waits for the response to request Id.
+type Cancel struct {
+	Id    string
+	Error *Error
+}
+
 type Blob struct {
 	Type      BlobType
 	Request   *Request     `json:",omitempty"`
 	Response  *Response    `json:",omitempty"`
 	Construct *Deconstruct `json:",omitempty"`
 	Destruct  *Deconstruct `json:",omitempty"`
+	Cancel    *Cancel      `json:",omitempty"`
 }
@@ -33,6 +33,12 @@ type ResourceProxy struct {
 type ResourceFinder func(askerId, resourceId string) ([]interface{}, error)
 
 func HandleRequest(emitter Emitter, resourceFinder ResourceFinder, request *messages.Request) error {
+	return HandleRequestContext(emitter, resourceFinder, request, nil)
+}
+
+// HandleRequestContext handles request and closes the Done channel of any *messages.Context
+// given to the called method when done is closed.
+func HandleRequestContext(emitter Emitter, resourceFinder ResourceFinder, request *messages.Request, done <-chan struct{}) error {
 	resources, err := resourceFinder(request.Header.Source, request.Resource)
 	if err != nil {
 		return emitter.Error(request, &messages.Error{
@@ -127,6 +133,7 @@ func HandleRequest(emitter Emitter, resourceFinder ResourceFinder, request *mess
 				if mt.NumIn() > 0 && mt.In(0) == contextType {
 					paramVals = append([]reflect.Value{reflect.ValueOf(&messages.Context{
 						Request: request,
+						Done:    done,
 					})}, paramVals...)
 				}
 
@@ -23,18 +23,19 @@ func setrlimit(i int, r *syscall.Rlimit) {
 	}
 }
 
+// The Go runtime reserves far more address space than it uses, and has already mapped more than a
+// few megabytes when init runs, so tight memory limits only make children die the next time the heap
+// grows. Memory use is instead charged to the owner, and RLIMIT_DATA only stops runaway children.
 const (
-	RLIMIT_AS     = 1 << 22
 	RLIMIT_CORE   = 0
 	RLIMIT_CPU    = 1
-	RLIMIT_DATA   = 1 << 22
+	RLIMIT_DATA   = 1 << 28
 	RLIMIT_FSIZE  = 0
 	RLIMIT_NOFILE = 3
 	RLIMIT_STACK  = 1 << 23
 )
 
 func init() {
-	setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{RLIMIT_AS, RLIMIT_AS})
 	setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{RLIMIT_CORE, RLIMIT_CORE})
 	setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{RLIMIT_CPU, RLIMIT_CPU})
 	setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{RLIMIT_DATA, RLIMIT_DATA})
@@ -80,6 +81,8 @@ type slaveDriver struct {
 	emitLock           sync.Mutex
 	flyingRequests     map[string]*flyingRequest
 	flyingRequestsLock sync.Mutex
+	handling           map[string]chan struct{}
+	handlingLock       sync.Mutex
 }
 
 type SlaveGenerator func(interfaces.MCP) interfaces.Describable
@@ -90,6 +93,7 @@ func newDriver(gen SlaveGenerator) *slaveDriver {
 		slaves:         map[string]interfaces.Describable{},
 		generator:      gen,
 		flyingRequests: map[string]*flyingRequest{},
+		handling:       map[string]chan struct{}{},
 	}
 	return driver
 }
@@ -119,7 +123,26 @@ func (s *slaveDriver) findSlave(source, resource string) ([]interface{}, error)
 }
 
 func (s *slaveDriver) handleRequest(request *messages.Request) {
-	s.logErr(proc.HandleRequest(s.emit, s.findSlave, request))
+	done := make(chan struct{})
+	s.handlingLock.Lock()
+	s.handling[request.Header.Id] = done
+	s.handlingLock.Unlock()
+	defer func() {
+		s.handlingLock.Lock()
+		delete(s.handling, request.Header.Id)
+		s.handlingLock.Unlock()
+	}()
+	s.logErr(proc.HandleRequestContext(s.emit, s.findSlave, request, done))
+}
+
+func (s *slaveDriver) cancel(c *messages.Cancel) {
+	s.handlingLock.Lock()
+	done, found := s.handling[c.Id]
+	delete(s.handling, c.Id)
+	s.handlingLock.Unlock()
+	if found {
+		close(done)
+	}
 }
 
 func (s *slaveDriver) emitRequest(verb *messages.Verb, source, resource, method string, params, result interface{}) *messages.Error {
@@ -169,6 +192,10 @@ func (s *slaveDriver) emitRequest(verb *messages.Verb, source, resource, method
 
 	flying.waitGroup.Wait()
 
+	if herr := flying.response.Header.Error; herr != nil {
+		return herr
+	}
+
 	if result != nil {
 		if err := json.Unmarshal([]byte(flying.response.Result), result); err != nil {
 			return &messages.Error{
@@ -178,18 +205,10 @@ func (s *slaveDriver) emitRequest(verb *messages.Verb, source, resource, method
 		}
 	}
 
-	if herr := flying.response.Header.Error; herr != nil {
-		return herr
-	}
-
 	return nil
 }
 
 func (s *slaveDriver) handleResponse(response *messages.Response) {
-	if response.Header.Error != nil {
-		log.Fatal(fmt.Errorf("%v: %v", response.Header.Error.Message, response.Header.Error.Code))
-	}
-
 	s.flyingRequestsLock.Lock()
 	flying, found := s.flyingRequests[response.Header.Id]
 	delete(s.flyingRequests, response.Header.Id)
@@ -269,8 +288,11 @@ func (s *slaveDriver) loop() {
 			go s.handleResponse(blob.Response)
 		case messages.BlobTypeDestruct:
 			go s.destruct(blob.Destruct)
+		case messages.BlobTypeCancel:
+			go s.cancel(blob.Cancel)
 		default:
-			log.Fatal(errors.ErrUnknownBlobType)
+			// Servers newer than this binary may send blobs it doesn't know, which it can do without.
+			log.Printf("%v: %v", errors.ErrUnknownBlobType, blob.Type)
 		}
 	}
 }
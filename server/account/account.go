@@ -0,0 +1,120 @@
+package account
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+)
+
+const (
+	// Window is how often the CPU budget of an owner is refilled.
+	Window = time.Hour
+	// CPUQuota is how much CPU time the processes of an owner may use per Window.
+	CPUQuota = time.Minute
+	// RSSQuota is how much resident memory the processes of an owner may use at any given time.
+	RSSQuota = 1 << 28
+	// StorageQuota is how many bytes of state the resources of an owner may store.
+	StorageQuota = 1 << 20
+)
+
+var ErrStorageQuota = fmt.Errorf("Storage quota of %v bytes exceeded", StorageQuota)
+
+// Account keeps the running totals of the resources used by the processes of an owner.
+type Account struct {
+	Owner       string
+	CPU         time.Duration
+	WindowStart time.Time
+	WindowCPU   time.Duration
+	RSS         int64
+	Storage     int64
+	Suspended   bool
+	UpdatedAt   time.Time
+	CreatedAt   time.Time
+}
+
+func (a *Account) WindowEnd() time.Time {
+	return a.WindowStart.Add(Window)
+}
+
+func (a *Account) OverCPU() bool {
+	return a.WindowCPU > CPUQuota
+}
+
+func (a *Account) OverRSS() bool {
+	return a.RSS > RSSQuota
+}
+
+func (a *Account) OverStorage() bool {
+	return a.Storage > StorageQuota
+}
+
+func (a *Account) charge(now time.Time, cpu time.Duration, rss int64) {
+	if now.After(a.WindowEnd()) {
+		a.WindowStart = now
+		a.WindowCPU = 0
+	}
+	a.CPU += cpu
+	a.WindowCPU += cpu
+	a.RSS = rss
+	a.Suspended = a.OverCPU()
+	a.UpdatedAt = now
+}
+
+// Get returns the account of owner, or an empty account if owner hasn't used anything yet.
+func Get(p *persist.Persister, owner string) (*Account, error) {
+	acc := &Account{}
+	if err := p.Get(owner, acc); err == persist.ErrNotFound {
+		now := time.Now()
+		return &Account{
+			Owner:       owner,
+			WindowStart: now,
+			UpdatedAt:   now,
+			CreatedAt:   now,
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// Charge adds cpu to the account of owner, records the current rss and updates whether
+// the owner is over the CPU budget for the current window.
+func Charge(p *persist.Persister, owner string, cpu time.Duration, rss int64) (*Account, error) {
+	var acc *Account
+	if err := p.Transact(func(p *persist.Persister) error {
+		var err error
+		if acc, err = Get(p, owner); err != nil {
+			return err
+		}
+		acc.charge(time.Now(), cpu, rss)
+		return p.Put(owner, acc)
+	}); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// ChargeStorage adds delta bytes to the storage used by owner, unless that would bring an owner
+// other than the house over StorageQuota.
+func ChargeStorage(p *persist.Persister, owner string, delta int64) (*Account, error) {
+	var acc *Account
+	if err := p.Transact(func(p *persist.Persister) error {
+		var err error
+		if acc, err = Get(p, owner); err != nil {
+			return err
+		}
+		acc.Storage += delta
+		if acc.Storage < 0 {
+			acc.Storage = 0
+		}
+		if delta > 0 && owner != "" && acc.OverStorage() {
+			return ErrStorageQuota
+		}
+		acc.UpdatedAt = time.Now()
+		return p.Put(owner, acc)
+	}); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
@@ -0,0 +1,106 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+)
+
+func TestChargeWindow(t *testing.T) {
+	start := time.Now()
+	acc := &Account{
+		WindowStart: start,
+	}
+
+	acc.charge(start.Add(time.Minute), CPUQuota/2, 10)
+	acc.charge(start.Add(2*time.Minute), CPUQuota/4, 20)
+	if acc.WindowCPU != CPUQuota*3/4 || acc.CPU != CPUQuota*3/4 {
+		t.Errorf("Got %v CPU in window and %v in total, wanted %v of both", acc.WindowCPU, acc.CPU, CPUQuota*3/4)
+	}
+	if acc.RSS != 20 {
+		t.Errorf("Got RSS %v, wanted the latest 20", acc.RSS)
+	}
+	if acc.Suspended {
+		t.Errorf("Wanted no suspension below the quota")
+	}
+
+	acc.charge(start.Add(3*time.Minute), CPUQuota/2, 20)
+	if !acc.Suspended {
+		t.Errorf("Wanted suspension over the quota")
+	}
+	// More use while suspended, like the CPU used before the stop, doesn't change anything.
+	acc.charge(start.Add(4*time.Minute), 0, 20)
+	if !acc.Suspended {
+		t.Errorf("Wanted suspension to last until the window ends")
+	}
+
+	later := start.Add(Window + time.Second)
+	acc.charge(later, time.Second, 20)
+	if acc.Suspended {
+		t.Errorf("Wanted a new window to lift the suspension")
+	}
+	if !acc.WindowStart.Equal(later) || acc.WindowCPU != time.Second {
+		t.Errorf("Got window from %v with %v CPU, wanted a new window from %v with 1s", acc.WindowStart, acc.WindowCPU, later)
+	}
+	if want := CPUQuota*5/4 + time.Second; acc.CPU != want {
+		t.Errorf("Got %v CPU in total, wanted %v", acc.CPU, want)
+	}
+}
+
+func TestChargeOverQuotaInOneGo(t *testing.T) {
+	start := time.Now()
+	acc := &Account{
+		WindowStart: start,
+	}
+	acc.charge(start, CPUQuota+time.Second, RSSQuota+1)
+	if !acc.Suspended || !acc.OverCPU() || !acc.OverRSS() {
+		t.Errorf("Got %+v, wanted suspended and over both quotas", acc)
+	}
+}
+
+func TestChargePersists(t *testing.T) {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if _, err := Charge(p, "owner", time.Second, 10); err != nil {
+		t.Fatal(err)
+	}
+	acc, err := Charge(p, "owner", CPUQuota, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Suspended {
+		t.Errorf("Wanted suspension over the quota")
+	}
+	stored, err := Get(p, "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Owner != "owner" || stored.WindowCPU != CPUQuota+time.Second || stored.RSS != 30 || !stored.Suspended {
+		t.Errorf("Got %+v stored, wanted the charges of both calls", stored)
+	}
+}
+
+func TestChargeStorage(t *testing.T) {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if _, err := ChargeStorage(p, "owner", StorageQuota); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ChargeStorage(p, "owner", 1); err != ErrStorageQuota {
+		t.Errorf("Got %v, wanted %v", err, ErrStorageQuota)
+	}
+	acc, err := ChargeStorage(p, "owner", -10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Storage != StorageQuota-10 {
+		t.Errorf("Got storage %v, wanted %v", acc.Storage, StorageQuota-10)
+	}
+	// Refunds never go below nothing, in case the charges were lost.
+	if acc, err = ChargeStorage(p, "owner", -2*StorageQuota); err != nil || acc.Storage != 0 {
+		t.Errorf("Got %+v, %v, wanted no storage", acc, err)
+	}
+	// The house has no quota.
+	if _, err := ChargeStorage(p, "", 2*StorageQuota); err != nil {
+		t.Errorf("Got %v, wanted the house to be over quota", err)
+	}
+}
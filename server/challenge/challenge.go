@@ -0,0 +1,43 @@
+package challenge
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+)
+
+// Challenge records an attempt by Attacker to pwn Target by mirroring its transform function.
+// The transform of Attacker turns Input into AttackerOutput, which the transform of Target turns
+// into TargetOutput, and the challenge succeeds if that is Input again.
+type Challenge struct {
+	Id             string
+	Attacker       string
+	AttackerOwner  string
+	Target         string
+	TargetOwner    string
+	Input          string
+	AttackerOutput string
+	TargetOutput   string
+	Success        bool
+	CreatedAt      time.Time
+}
+
+// Save stores c with a new id.
+func (c *Challenge) Save(p *persist.Persister) error {
+	c.Id = fmt.Sprintf("%x%x", rand.Int63(), rand.Int63())
+	c.CreatedAt = time.Now()
+	return p.Put(c.Id, c)
+}
+
+// History returns the challenges against target, newest first.
+func History(p *persist.Persister, target string) ([]Challenge, error) {
+	challenges := []Challenge{}
+	if err := p.Find(persist.NewF(Challenge{
+		Target: target,
+	}).Add("Target").Order("CreatedAt", true), &challenges); err != nil {
+		return nil, err
+	}
+	return challenges, nil
+}
@@ -1,7 +1,6 @@
 package client
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"log"
@@ -13,6 +12,7 @@ import (
 	"github.com/zond/hackyhack/server/persist"
 	"github.com/zond/hackyhack/server/resource"
 	"github.com/zond/hackyhack/server/router"
+	"github.com/zond/hackyhack/server/telnet"
 	"github.com/zond/hackyhack/server/user"
 )
 
@@ -21,10 +21,19 @@ type Handler interface {
 	UnregisterClient()
 }
 
+// Conn is a line based connection to a player, like a telnet connection or a web socket.
+type Conn interface {
+	io.Writer
+	ReadLine() (string, error)
+	SetEcho(bool) error
+	Terminal() telnet.Terminal
+	SendGMCP(pkg string, payload []byte) error
+}
+
 type Client struct {
 	persister *persist.Persister
 	router    *router.Router
-	conn      net.Conn
+	conn      Conn
 	handler   Handler
 }
 
@@ -40,6 +49,25 @@ func (c *Client) Send(s string) error {
 	return err
 }
 
+// SetEcho turns the echoing of input by the player's client on or off.
+func (c *Client) SetEcho(echo bool) error {
+	return c.conn.SetEcho(echo)
+}
+
+func (c *Client) Terminal() *messages.Terminal {
+	t := c.conn.Terminal()
+	return &messages.Terminal{
+		Type:   t.Type,
+		Width:  t.Width,
+		Height: t.Height,
+		GMCP:   t.GMCP,
+	}
+}
+
+func (c *Client) SendGMCP(pkg string, payload []byte) error {
+	return c.conn.SendGMCP(pkg, payload)
+}
+
 type mcpHandler struct {
 	client *Client
 	user   *user.User
@@ -50,6 +78,17 @@ func (mh *mcpHandler) HandleClientInput(s string) error {
 	if err != nil {
 		return err
 	}
+	if m.Suspended() {
+		// The avatar is stopped, so the quota has to come from the router.
+		if fields := strings.Fields(s); len(fields) > 0 && strings.ToLower(fields[0]) == "quota" {
+			quota, merr := mh.client.router.Quota(mh.user.Resource)
+			if merr != nil {
+				return merr.ToErr()
+			}
+			return mh.client.Send(quota.String())
+		}
+		return fmt.Errorf("Your objects are suspended until your CPU budget is refilled, see quota.")
+	}
 	var merr *messages.Error
 	if err := m.Call(mh.user.Resource, mh.user.Resource, "HandleClientInput", []string{s}, &[]interface{}{&merr}); err != nil {
 		return err
@@ -99,22 +138,35 @@ func (c *Client) unregisterClient() {
 	c.handler.UnregisterClient()
 }
 
+// Handle serves a telnet connection.
 func (c *Client) Handle(conn net.Conn) {
+	telnetConn := telnet.New(conn)
+	if err := telnetConn.Negotiate(); err != nil {
+		log.Print(err)
+	}
+	c.Serve(telnetConn)
+}
+
+// Serve welcomes the player on conn to the lobby, and hands the lines read from conn to the lobby
+// or the avatar of the player until reading fails.
+func (c *Client) Serve(conn Conn) {
 	c.conn = conn
-	scanner := bufio.NewScanner(c.conn)
 	lobby := lobby.New(c.persister, c)
 	if err := lobby.Welcome(); err != nil {
 		log.Print(err)
 	}
 	c.handler = lobby
 	defer c.unregisterClient()
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if e := c.handler.HandleClientInput(line); e != nil {
+	for {
+		line, err := c.conn.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				log.Print(err)
+			}
+			return
+		}
+		if e := c.handler.HandleClientInput(strings.TrimSpace(line)); e != nil {
 			c.Send(fmt.Sprintf("%v\n", e.Error()))
 		}
 	}
-	if scanner.Err() != io.EOF {
-		log.Print(scanner.Err())
-	}
 }
@@ -0,0 +1,123 @@
+package credentials
+
+import (
+	"crypto/hmac"
+	"fmt"
+
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/session"
+	"github.com/zond/hackyhack/server/user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Cost is the bcrypt cost of new password hashes.
+	Cost = bcrypt.DefaultCost
+	// MinPasswordLength and MaxPasswordLength are the bounds of new passwords, the upper one
+	// set by bcrypt.
+	MinPasswordLength = 6
+	MaxPasswordLength = 72
+)
+
+var (
+	ErrUnknownUser   = fmt.Errorf("Unknown user")
+	ErrWrongPassword = fmt.Errorf("Incorrect password")
+	ErrPasswordSize  = fmt.Errorf("Passwords have to be between %v and %v bytes", MinPasswordLength, MaxPasswordLength)
+)
+
+// SetPassword replaces any password of u with a hash of password. Doesn't save u.
+func SetPassword(u *user.User, password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrPasswordSize
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), Cost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	u.Password = ""
+	return nil
+}
+
+// Matches returns whether password is the password of u.
+func Matches(u *user.User, password string) bool {
+	if u.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+	}
+	// Users from before passwords were hashed.
+	return u.Password != "" && hmac.Equal([]byte(u.Password), []byte(password))
+}
+
+// Authenticate returns the user named username if password matches, and replaces the password
+// of users still having plaintext passwords with a hash.
+func Authenticate(p *persist.Persister, username, password string) (*user.User, error) {
+	users := []user.User{}
+	if err := p.Find(persist.NewF(user.User{
+		Username: username,
+	}).Add("Username"), &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUnknownUser
+	}
+	for index := range users {
+		found := &users[index]
+		if !Matches(found, password) {
+			continue
+		}
+		if found.PasswordHash == "" {
+			if err := upgrade(p, found, password); err != nil {
+				return nil, err
+			}
+		}
+		return found, nil
+	}
+	return nil, ErrWrongPassword
+}
+
+// upgrade hashes the plaintext password of u, which may be too short or long to be set today.
+func upgrade(p *persist.Persister, u *user.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), Cost)
+	if err != nil {
+		return err
+	}
+	return p.Transact(func(p *persist.Persister) error {
+		if err := p.Get(u.Username, u); err != nil {
+			return err
+		}
+		u.PasswordHash = string(hash)
+		u.Password = ""
+		return p.Put(u.Username, u)
+	})
+}
+
+// ChangePassword sets the password of the user controlling resource to newPassword, if oldPassword
+// is its current password, and ends the sessions of the user.
+func ChangePassword(p *persist.Persister, resource, oldPassword, newPassword string) error {
+	return p.Transact(func(p *persist.Persister) error {
+		users := []user.User{}
+		if err := p.Find(persist.NewF(user.User{
+			Resource: resource,
+		}).Add("Resource"), &users); err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return ErrUnknownUser
+		}
+		for index := range users {
+			u := &users[index]
+			if !Matches(u, oldPassword) {
+				continue
+			}
+			if err := SetPassword(u, newPassword); err != nil {
+				return err
+			}
+			if err := p.Put(u.Username, u); err != nil {
+				return err
+			}
+			// Whoever knew the old password shouldn't stay logged in.
+			return session.RevokeAll(p, u.Username)
+		}
+		return ErrWrongPassword
+	})
+}
@@ -0,0 +1,72 @@
+package credentials
+
+import (
+	"testing"
+
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/session"
+	"github.com/zond/hackyhack/server/user"
+)
+
+func newPersister(t *testing.T) *persist.Persister {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if err := p.Index(user.User{}, "Username", "Resource"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Index(session.Session{}, "Username"); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestUpgradePlaintext(t *testing.T) {
+	p := newPersister(t)
+	if err := p.Put("bob", &user.User{Username: "bob", Password: "pw", Resource: "r"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(p, "bob", "wrong"); err != ErrWrongPassword {
+		t.Errorf("Got %v, wanted %v", err, ErrWrongPassword)
+	}
+	if _, err := Authenticate(p, "alice", "pw"); err != ErrUnknownUser {
+		t.Errorf("Got %v, wanted %v", err, ErrUnknownUser)
+	}
+	if _, err := Authenticate(p, "bob", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	stored := &user.User{}
+	if err := p.Get("bob", stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != "" || stored.PasswordHash == "" {
+		t.Errorf("Wanted only a hash stored, got %+v", stored)
+	}
+	if _, err := Authenticate(p, "bob", "pw"); err != nil {
+		t.Errorf("Wanted the upgraded password to work, got %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	p := newPersister(t)
+	u := &user.User{Username: "bob", Resource: "r"}
+	if err := SetPassword(u, "secret1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(u.Username, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := ChangePassword(p, "r", "wrong", "secret2"); err != ErrWrongPassword {
+		t.Errorf("Got %v, wanted %v", err, ErrWrongPassword)
+	}
+	if err := ChangePassword(p, "r", "secret1", "short"); err != ErrPasswordSize {
+		t.Errorf("Got %v, wanted %v", err, ErrPasswordSize)
+	}
+	if err := ChangePassword(p, "r", "secret1", "secret2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(p, "bob", "secret1"); err != ErrWrongPassword {
+		t.Errorf("Got %v, wanted %v", err, ErrWrongPassword)
+	}
+	if _, err := Authenticate(p, "bob", "secret2"); err != nil {
+		t.Error(err)
+	}
+}
@@ -27,7 +27,7 @@ func New(m interfaces.MCP) interfaces.Describable {
 		M: m,
 	})
 	go func() {
-		if err := util.Subscribe(m, &messages.Subscription{
+		if _, err := util.Subscribe(m, &messages.Subscription{
 			HandlerName: "Event",
 		}); err != nil {
 			util.Fatal(err)
@@ -2,7 +2,6 @@ package lobby
 
 import (
 	"bytes"
-	"crypto/hmac"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -15,8 +14,10 @@ import (
 	"time"
 
 	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/credentials"
 	"github.com/zond/hackyhack/server/persist"
 	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
 	"github.com/zond/hackyhack/server/user"
 )
 
@@ -34,6 +35,7 @@ func init() {
 
 type Client interface {
 	Send(string) error
+	SetEcho(bool) error
 	Authorize(*user.User) error
 }
 
@@ -41,6 +43,7 @@ type state int
 
 const (
 	welcome state = iota
+	password
 	createUser
 )
 
@@ -48,6 +51,7 @@ type Lobby struct {
 	client    Client
 	persister *persist.Persister
 	state     state
+	username  string
 	user      *user.User
 }
 
@@ -62,7 +66,7 @@ func New(p *persist.Persister, c Client) *Lobby {
 func (l *Lobby) UnregisterClient() {
 }
 
-var loginReg = regexp.MustCompile("^login (\\w+) (\\w+)$")
+var loginReg = regexp.MustCompile("^login (\\w+)(?: (\\S+))?$")
 
 func (l *Lobby) HandleClientInput(s string) error {
 	switch l.state {
@@ -88,6 +92,9 @@ func (l *Lobby) HandleClientInput(s string) error {
 				if err := p.Put(r.Id, r); err != nil {
 					return err
 				}
+				if _, err := revision.Save(p, r.Id, l.user.Resource, r.Code, ""); err != nil {
+					return err
+				}
 				return nil
 			}); err != nil {
 				return err
@@ -97,48 +104,71 @@ func (l *Lobby) HandleClientInput(s string) error {
 			l.state = welcome
 			return l.client.Send(`
 Usage:
-login USERNAME PASSWORD
+login USERNAME
 `)
 		}
 		return l.client.Send(`
 (y/n)
 `)
+	case password:
+		l.state = welcome
+		if err := l.client.SetEcho(true); err != nil {
+			return err
+		}
+		// The client didn't echo the line break either.
+		if err := l.client.Send("\n"); err != nil {
+			return err
+		}
+		return l.login(l.username, s)
 	case welcome:
 		if match := loginReg.FindStringSubmatch(s); match == nil {
 			return l.client.Send(`
 Usage:
-login USERNAME PASSWORD
+login USERNAME
 `)
-		} else {
-			users := []user.User{}
-			if err := l.persister.Find(persist.NewF(user.User{
-				Username: match[1],
-			}).Add("Username"), &users); err != nil {
+		} else if match[2] == "" {
+			l.state = password
+			l.username = match[1]
+			if err := l.client.SetEcho(false); err != nil {
 				return err
 			}
-			if len(users) == 0 {
-				l.state = createUser
-				l.user = &user.User{
-					Username:  match[1],
-					Password:  match[2],
-					Resource:  fmt.Sprintf("%x%x", rand.Int63(), rand.Int63()),
-					Container: messages.VoidResource,
-				}
-				return l.client.Send(`
+			return l.client.Send("Password: ")
+		} else {
+			return l.login(match[1], match[2])
+		}
+	}
+	return nil
+}
+
+func (l *Lobby) login(username, passwd string) error {
+	u, err := credentials.Authenticate(l.persister, username, passwd)
+	switch err {
+	case nil:
+		return l.client.Authorize(u)
+	case credentials.ErrUnknownUser:
+		newUser := &user.User{
+			Username:  username,
+			Resource:  fmt.Sprintf("%x%x", rand.Int63(), rand.Int63()),
+			Container: messages.VoidResource,
+		}
+		if err := credentials.SetPassword(newUser, passwd); err == credentials.ErrPasswordSize {
+			return l.client.Send(fmt.Sprintf(`
+%v.
+`, err))
+		} else if err != nil {
+			return err
+		}
+		l.state = createUser
+		l.user = newUser
+		return l.client.Send(`
 User not found, create? (y/n)
 `)
-			}
-			for index := range users {
-				if hmac.Equal([]byte(match[2]), []byte(users[index].Password)) {
-					return l.client.Authorize(&users[index])
-				}
-			}
-			return l.client.Send(`
+	case credentials.ErrWrongPassword:
+		return l.client.Send(`
 Incorrect password.
 `)
-		}
 	}
-	return nil
+	return err
 }
 
 func (l *Lobby) Welcome() error {
@@ -149,6 +179,6 @@ Welcome
 	}
 	return l.client.Send(`
 Usage:
-login USERNAME PASSWORD
+login USERNAME
 `)
 }
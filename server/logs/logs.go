@@ -0,0 +1,160 @@
+package logs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+const (
+	// DefaultSize is how many lines are kept per resource.
+	DefaultSize = 256
+	// MaxLine is how many bytes of text a line keeps.
+	MaxLine = 4096
+	// subscriberBuffer is how many lines a subscriber can lag behind before missing lines.
+	subscriberBuffer = 64
+)
+
+const (
+	SourceStderr = "stderr"
+	SourceLog    = "log"
+)
+
+// Ring keeps the last lines logged by a resource, and forwards new lines to subscribers.
+type Ring struct {
+	lines       []messages.LogLine
+	next        int
+	full        bool
+	subscribers map[chan messages.LogLine]bool
+	lock        sync.Mutex
+}
+
+func newRing(size int) *Ring {
+	return &Ring{
+		lines:       make([]messages.LogLine, size),
+		subscribers: map[chan messages.LogLine]bool{},
+	}
+}
+
+func (r *Ring) append(line messages.LogLine) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	for ch := range r.subscribers {
+		// Slow subscribers miss lines rather than block the logging resource.
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+func (r *Ring) empty() bool {
+	return r.next == 0 && !r.full
+}
+
+func (r *Ring) clear() {
+	for index := range r.lines {
+		r.lines[index] = messages.LogLine{}
+	}
+	r.next = 0
+	r.full = false
+}
+
+func (r *Ring) contents() []messages.LogLine {
+	if !r.full {
+		return append([]messages.LogLine{}, r.lines[:r.next]...)
+	}
+	return append(append([]messages.LogLine{}, r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+// Buffers keeps a Ring per resource that has logged or is subscribed to.
+type Buffers struct {
+	size  int
+	rings map[string]*Ring
+	lock  sync.Mutex
+}
+
+func NewBuffers(size int) *Buffers {
+	return &Buffers{
+		size:  size,
+		rings: map[string]*Ring{},
+	}
+}
+
+func (b *Buffers) ring(resource string) *Ring {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ring, found := b.rings[resource]
+	if !found {
+		ring = newRing(b.size)
+		b.rings[resource] = ring
+	}
+	return ring
+}
+
+func (b *Buffers) Append(resource, source, text string) {
+	b.ring(resource).append(messages.LogLine{
+		Time:     time.Now(),
+		Resource: resource,
+		Source:   source,
+		Text:     text,
+	})
+}
+
+// Lines returns the kept lines of resource, oldest first.
+func (b *Buffers) Lines(resource string) []messages.LogLine {
+	b.lock.Lock()
+	ring, found := b.rings[resource]
+	b.lock.Unlock()
+	if !found {
+		return []messages.LogLine{}
+	}
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	return ring.contents()
+}
+
+// Subscribe returns the kept lines of resource, a channel of the lines logged after them, and a function
+// that must be called when done with the channel.
+func (b *Buffers) Subscribe(resource string) ([]messages.LogLine, <-chan messages.LogLine, func()) {
+	ring := b.ring(resource)
+	ch := make(chan messages.LogLine, subscriberBuffer)
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	ring.subscribers[ch] = true
+	return ring.contents(), ch, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		ring.lock.Lock()
+		defer ring.lock.Unlock()
+		delete(ring.subscribers, ch)
+		// Rings only created to subscribe to quiet resources aren't worth keeping.
+		if len(ring.subscribers) == 0 && ring.empty() && b.rings[resource] == ring {
+			delete(b.rings, resource)
+		}
+	}
+}
+
+// Drop forgets the lines of resource. Its ring is kept while subscribed to, so that subscribers get
+// the lines of the next run.
+func (b *Buffers) Drop(resource string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ring, found := b.rings[resource]
+	if !found {
+		return
+	}
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	if len(ring.subscribers) > 0 {
+		ring.clear()
+	} else {
+		delete(b.rings, resource)
+	}
+}
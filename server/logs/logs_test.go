@@ -0,0 +1,94 @@
+package logs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func texts(b *Buffers, resource string) []string {
+	result := []string{}
+	for _, line := range b.Lines(resource) {
+		result = append(result, line.Text)
+	}
+	return result
+}
+
+func TestRing(t *testing.T) {
+	b := NewBuffers(3)
+	if got := texts(b, "a"); len(got) != 0 {
+		t.Errorf("got %v, want nothing", got)
+	}
+	for i := 0; i < 2; i++ {
+		b.Append("a", SourceLog, fmt.Sprint(i))
+	}
+	if got := fmt.Sprint(texts(b, "a")); got != "[0 1]" {
+		t.Errorf("got %v, want [0 1]", got)
+	}
+	for i := 2; i < 5; i++ {
+		b.Append("a", SourceLog, fmt.Sprint(i))
+	}
+	if got := fmt.Sprint(texts(b, "a")); got != "[2 3 4]" {
+		t.Errorf("got %v, want [2 3 4]", got)
+	}
+	if got := texts(b, "b"); len(got) != 0 {
+		t.Errorf("got %v, want nothing", got)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := NewBuffers(3)
+	b.Append("a", SourceLog, "old")
+	lines, ch, cancel := b.Subscribe("a")
+	if len(lines) != 1 || lines[0].Text != "old" {
+		t.Errorf("got %+v, want [old]", lines)
+	}
+	b.Append("a", SourceStderr, "new")
+	if line := <-ch; line.Text != "new" || line.Source != SourceStderr {
+		t.Errorf("got %+v, want new from stderr", line)
+	}
+	cancel()
+	b.Append("a", SourceLog, "ignored")
+	select {
+	case line := <-ch:
+		t.Errorf("got %+v after cancelling", line)
+	default:
+	}
+}
+
+func TestLinesKeepsNoRing(t *testing.T) {
+	b := NewBuffers(3)
+	b.Lines("a")
+	if len(b.rings) != 0 {
+		t.Errorf("got %v rings after reading, want none", len(b.rings))
+	}
+	_, _, cancel := b.Subscribe("a")
+	cancel()
+	if len(b.rings) != 0 {
+		t.Errorf("got %v rings after unsubscribing from a quiet resource, want none", len(b.rings))
+	}
+}
+
+func TestDrop(t *testing.T) {
+	b := NewBuffers(3)
+	b.Append("a", SourceLog, "old")
+	b.Drop("a")
+	if len(b.rings) != 0 {
+		t.Errorf("got %v rings after dropping, want none", len(b.rings))
+	}
+
+	b.Append("a", SourceLog, "old")
+	_, ch, cancel := b.Subscribe("a")
+	b.Drop("a")
+	if got := texts(b, "a"); len(got) != 0 {
+		t.Errorf("got %v, want nothing after dropping", got)
+	}
+	// Subscribers keep getting the lines of the next run.
+	b.Append("a", SourceLog, "new")
+	if line := <-ch; line.Text != "new" {
+		t.Errorf("got %+v, want new", line)
+	}
+	cancel()
+	if got := fmt.Sprint(texts(b, "a")); got != "[new]" {
+		t.Errorf("got %v, want [new] kept after unsubscribing", got)
+	}
+}
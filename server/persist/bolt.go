@@ -0,0 +1,331 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+type boltIndexes struct {
+	lock sync.RWMutex
+	m    map[string][]string
+}
+
+func (i *boltIndexes) get(kind string) []string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.m[kind]
+}
+
+type Bolt struct {
+	db      *bolt.DB
+	tx      *bolt.Tx
+	indexes *boltIndexes
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Bolt{
+		db: db,
+		indexes: &boltIndexes{
+			m: map[string][]string{},
+		},
+	}, nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func (b *Bolt) view(f func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return f(b.tx)
+	}
+	return b.db.View(f)
+}
+
+func (b *Bolt) update(f func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return f(b.tx)
+	}
+	return b.db.Update(f)
+}
+
+func (b *Bolt) Transact(f func(p Backend) error) error {
+	if b.tx != nil {
+		return f(b)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return f(&Bolt{
+			db:      b.db,
+			tx:      tx,
+			indexes: b.indexes,
+		})
+	})
+}
+
+func (b *Bolt) Get(kind, key string, value interface{}) error {
+	return b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(kind))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, value)
+	})
+}
+
+func indexBucket(kind, field string) []byte {
+	return []byte(fmt.Sprintf("index/%v/%v", kind, field))
+}
+
+// indexEntries returns the index keys, i.e. encoded field value, a zero byte and the key, of data.
+func indexEntries(fields []string, key string, data []byte) (map[string][]byte, error) {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	result := map[string][]byte{}
+	for _, field := range fields {
+		if fieldData, found := raw[field]; found {
+			result[field] = append(append(append([]byte{}, sortable(fieldData)...), 0), key...)
+		}
+	}
+	return result, nil
+}
+
+// reindex replaces the index entries of old with those of data. A nil data only removes old.
+func (b *Bolt) reindex(tx *bolt.Tx, kind, key string, old, data []byte) error {
+	fields := b.indexes.get(kind)
+	if len(fields) == 0 {
+		return nil
+	}
+	if old != nil {
+		entries, err := indexEntries(fields, key, old)
+		if err != nil {
+			return err
+		}
+		for field, entry := range entries {
+			if err := tx.Bucket(indexBucket(kind, field)).Delete(entry); err != nil {
+				return err
+			}
+		}
+	}
+	if data == nil {
+		return nil
+	}
+	entries, err := indexEntries(fields, key, data)
+	if err != nil {
+		return err
+	}
+	for field, entry := range entries {
+		if err := tx.Bucket(indexBucket(kind, field)).Put(entry, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Put(kind, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+		var old []byte
+		if oldData := bucket.Get([]byte(key)); oldData != nil {
+			old = append(old, oldData...)
+		}
+		if err := bucket.Put([]byte(key), data); err != nil {
+			return err
+		}
+		return b.reindex(tx, kind, key, old, data)
+	})
+}
+
+func (b *Bolt) Del(kind, key string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(kind))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		oldData := bucket.Get([]byte(key))
+		if oldData == nil {
+			return ErrNotFound
+		}
+		old := append([]byte{}, oldData...)
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return b.reindex(tx, kind, key, old, nil)
+	})
+}
+
+// Index (re)builds the index bucket for field from scratch, to make sure values
+// written while the index wasn't declared get indexed as well.
+func (b *Bolt) Index(kind, field string) error {
+	b.indexes.lock.Lock()
+	defer b.indexes.lock.Unlock()
+	for _, indexed := range b.indexes.m[kind] {
+		if indexed == field {
+			return nil
+		}
+	}
+	if err := b.update(func(tx *bolt.Tx) error {
+		name := indexBucket(kind, field)
+		if tx.Bucket(name) != nil {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		idx, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket([]byte(kind))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			entries, err := indexEntries([]string{field}, string(k), v)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if err := idx.Put(entry, []byte{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	b.indexes.m[kind] = append(b.indexes.m[kind], field)
+	return nil
+}
+
+// rangeCandidates returns the keys in the index of the field of cond whose values are in the time range of cond.
+func (b *Bolt) rangeCandidates(tx *bolt.Tx, kind string, cond *condition) ([]string, error) {
+	bounds := [2][]byte{}
+	for index, value := range cond.values {
+		if value != nil {
+			indexKey, err := indexKey(value)
+			if err != nil {
+				return nil, err
+			}
+			bounds[index] = []byte(indexKey)
+		}
+	}
+	result := []string{}
+	cursor := tx.Bucket(indexBucket(kind, cond.field)).Cursor()
+	k, _ := cursor.First()
+	if bounds[0] != nil {
+		k, _ = cursor.Seek(bounds[0])
+	}
+	for ; k != nil; k, _ = cursor.Next() {
+		split := bytes.IndexByte(k, 0)
+		if bounds[1] != nil && bytes.Compare(k[:split], bounds[1]) >= 0 {
+			break
+		}
+		result = append(result, string(k[split+1:]))
+	}
+	return result, nil
+}
+
+// candidates returns the keys matching cond according to the index of its field.
+func (b *Bolt) candidates(tx *bolt.Tx, kind string, cond *condition) ([]string, error) {
+	if cond.op == opRange {
+		return b.rangeCandidates(tx, kind, cond)
+	}
+	prefixes := [][]byte{}
+	for _, value := range cond.values {
+		indexKey, err := indexKey(value)
+		if err != nil {
+			return nil, err
+		}
+		if cond.op == opPrefix {
+			prefixes = append(prefixes, []byte(strings.TrimSuffix(indexKey, "\"")))
+		} else {
+			prefixes = append(prefixes, append([]byte(indexKey), 0))
+		}
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	cursor := tx.Bucket(indexBucket(kind, cond.field)).Cursor()
+	for _, prefix := range prefixes {
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			key := string(k[bytes.IndexByte(k, 0)+1:])
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, key)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (b *Bolt) Find(kind string, filter *F, result interface{}) error {
+	resultVal := reflect.ValueOf(result).Elem()
+	start := resultVal.Len()
+	elemType := resultVal.Type().Elem()
+	add := func(k, v []byte) error {
+		vVal := reflect.New(elemType)
+		if err := json.Unmarshal(v, vVal.Interface()); err != nil {
+			return fmt.Errorf("json.Unmarshal of %q/%q failed: %v", kind, k, err)
+		}
+		if filter.matches(vVal.Elem()) {
+			resultVal.Set(reflect.Append(resultVal, vVal.Elem()))
+		}
+		return nil
+	}
+	if err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(kind))
+		if bucket == nil {
+			return nil
+		}
+		cond := filter.indexed(b.indexes.get(kind), opEquals, opIn, opPrefix)
+		if cond == nil {
+			if rangeCond := filter.indexed(b.indexes.get(kind), opRange); rangeCond != nil && rangeCond.timeRange() {
+				cond = rangeCond
+			}
+		}
+		if cond == nil {
+			return bucket.ForEach(add)
+		}
+		keys, err := b.candidates(tx, kind, cond)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if v := bucket.Get([]byte(key)); v != nil {
+				if err := add([]byte(key), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	filter.finish(resultVal, start)
+	return nil
+}
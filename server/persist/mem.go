@@ -6,14 +6,19 @@ import (
 	"sync"
 )
 
+// memIndex maps encoded field values to the keys having them.
+type memIndex map[string]map[string]bool
+
 type Mem struct {
-	lock sync.RWMutex
-	m    map[[2]string]interface{}
+	lock    sync.RWMutex
+	m       map[string]map[string]interface{}
+	indexes map[string]map[string]memIndex
 }
 
 func NewMem() *Mem {
 	return &Mem{
-		m: map[[2]string]interface{}{},
+		m:       map[string]map[string]interface{}{},
+		indexes: map[string]map[string]memIndex{},
 	}
 }
 
@@ -36,54 +41,151 @@ func (m *Mem) Transact(f func(p Backend) error) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	return f(&Mem{
-		m: m.m,
+		m:       m.m,
+		indexes: m.indexes,
 	})
 }
 
 func (m *Mem) Get(kind, key string, value interface{}) error {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
-	val, found := m.m[[2]string{kind, key}]
+	val, found := m.m[kind][key]
 	if !found {
 		return ErrNotFound
 	}
 	return m.cpy(val, value)
 }
 
+func (m *Mem) index(kind, key string, value interface{}, add bool) error {
+	val := reflect.ValueOf(value).Elem()
+	for field, idx := range m.indexes[kind] {
+		fieldVal := val.FieldByName(field)
+		if !fieldVal.IsValid() {
+			continue
+		}
+		indexKey, err := indexKey(fieldVal.Interface())
+		if err != nil {
+			return err
+		}
+		if add {
+			if idx[indexKey] == nil {
+				idx[indexKey] = map[string]bool{}
+			}
+			idx[indexKey][key] = true
+		} else {
+			delete(idx[indexKey], key)
+			if len(idx[indexKey]) == 0 {
+				delete(idx, indexKey)
+			}
+		}
+	}
+	return nil
+}
+
 func (m *Mem) Put(kind, key string, value interface{}) error {
+	cpy := reflect.New(reflect.TypeOf(value).Elem()).Interface()
+	if err := m.cpy(value, cpy); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values, found := m.m[kind]
+	if !found {
+		values = map[string]interface{}{}
+		m.m[kind] = values
+	}
+	if old, found := values[key]; found {
+		if err := m.index(kind, key, old, false); err != nil {
+			return err
+		}
+	}
+	if err := m.index(kind, key, cpy, true); err != nil {
+		return err
+	}
+	values[key] = cpy
+	return nil
+}
+
+func (m *Mem) Del(kind, key string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.m[[2]string{kind, key}] = value
+	old, found := m.m[kind][key]
+	if !found {
+		return ErrNotFound
+	}
+	if err := m.index(kind, key, old, false); err != nil {
+		return err
+	}
+	delete(m.m[kind], key)
 	return nil
 }
 
-func (m *Mem) matches(filter *F, val reflect.Value) bool {
-	for name, wanted := range filter.m {
-		field := val.FieldByName(name)
-		if !field.IsValid() {
-			return false
+func (m *Mem) Index(kind, field string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	fields, found := m.indexes[kind]
+	if !found {
+		fields = map[string]memIndex{}
+		m.indexes[kind] = fields
+	}
+	if _, found := fields[field]; found {
+		return nil
+	}
+	idx := memIndex{}
+	for key, value := range m.m[kind] {
+		indexKey, err := indexKey(reflect.ValueOf(value).Elem().FieldByName(field).Interface())
+		if err != nil {
+			return err
 		}
-		if field.Interface() != wanted {
-			return false
+		if idx[indexKey] == nil {
+			idx[indexKey] = map[string]bool{}
 		}
+		idx[indexKey][key] = true
 	}
-	return true
+	fields[field] = idx
+	return nil
+}
+
+func (m *Mem) candidates(kind string, filter *F) (map[string]interface{}, error) {
+	indexed := []string{}
+	for field := range m.indexes[kind] {
+		indexed = append(indexed, field)
+	}
+	cond := filter.indexed(indexed, opEquals, opIn)
+	if cond == nil {
+		return m.m[kind], nil
+	}
+	result := map[string]interface{}{}
+	for _, value := range cond.values {
+		indexKey, err := indexKey(value)
+		if err != nil {
+			return nil, err
+		}
+		for key := range m.indexes[kind][cond.field][indexKey] {
+			result[key] = m.m[kind][key]
+		}
+	}
+	return result, nil
 }
 
 func (m *Mem) Find(kind string, filter *F, result interface{}) error {
 	resultVal := reflect.ValueOf(result).Elem()
+	start := resultVal.Len()
 	m.lock.RLock()
 	defer m.lock.RUnlock()
-	for k, v := range m.m {
-		if k[0] == kind {
-			vVal := reflect.ValueOf(v).Elem()
-			if vVal.Type() != resultVal.Type().Elem() {
-				return fmt.Errorf("Incompatible types; %v and %v", vVal.Type(), resultVal.Type().Elem())
-			}
-			if m.matches(filter, vVal) {
-				resultVal.Set(reflect.Append(resultVal, vVal))
-			}
+	candidates, err := m.candidates(kind, filter)
+	if err != nil {
+		return err
+	}
+	for _, v := range candidates {
+		vVal := reflect.ValueOf(v).Elem()
+		if vVal.Type() != resultVal.Type().Elem() {
+			return fmt.Errorf("Incompatible types; %v and %v", vVal.Type(), resultVal.Type().Elem())
+		}
+		if filter.matches(vVal) {
+			resultVal.Set(reflect.Append(resultVal, vVal))
 		}
 	}
+	filter.finish(resultVal, start)
 	return nil
 }
@@ -1,8 +1,12 @@
 package persist
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 var ErrNotFound = fmt.Errorf("Not found")
@@ -27,22 +31,50 @@ func (p *Persister) Get(key string, result interface{}) error {
 	return p.Backend.Get(reflect.TypeOf(result).Elem().Name(), key, result)
 }
 
+// Del removes the value of the same kind as tmpl stored under key.
+func (p *Persister) Del(key string, tmpl interface{}) error {
+	tmplType := reflect.TypeOf(tmpl)
+	if tmplType.Kind() != reflect.Ptr || tmplType.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Template not pointer to struct")
+	}
+	return p.Backend.Del(tmplType.Elem().Name(), key)
+}
+
 type errors []error
 
 func (e errors) Error() string {
 	return fmt.Sprintf("%+v", []error(e))
 }
 
+type operator int
+
+const (
+	opEquals operator = iota
+	opPrefix
+	opRange
+	opIn
+)
+
+type condition struct {
+	field  string
+	op     operator
+	values []interface{}
+}
+
+// F is a filter for Persister#Find. Equality and prefix conditions take their
+// values from the template struct, range and set conditions take explicit values.
 type F struct {
-	val  reflect.Value
-	m    map[string]interface{}
-	errs errors
+	val   reflect.Value
+	conds []condition
+	order string
+	desc  bool
+	limit int
+	errs  errors
 }
 
 func NewF(tmpl interface{}) *F {
 	f := &F{
 		val: reflect.ValueOf(tmpl),
-		m:   map[string]interface{}{},
 	}
 	if f.val.Kind() != reflect.Struct {
 		f.errs = append(f.errs, fmt.Errorf("%v isn't a struct", f.val.Type()))
@@ -50,19 +82,297 @@ func NewF(tmpl interface{}) *F {
 	return f
 }
 
-func (f *F) Add(field string) *F {
+func (f *F) field(field string) (reflect.Value, bool) {
 	if len(f.errs) > 0 {
-		return f
+		return reflect.Value{}, false
 	}
 	val := f.val.FieldByName(field)
-	if val.IsValid() {
-		f.m[field] = val.Interface()
-	} else {
+	if !val.IsValid() {
 		f.errs = append(f.errs, fmt.Errorf("%v has no field %q", f.val.Type(), field))
+		return reflect.Value{}, false
+	}
+	return val, true
+}
+
+func (f *F) checkValue(field string, typ reflect.Type, value interface{}) bool {
+	if value == nil || !reflect.TypeOf(value).AssignableTo(typ) {
+		f.errs = append(f.errs, fmt.Errorf("%#v isn't assignable to %v.%v (%v)", value, f.val.Type(), field, typ))
+		return false
+	}
+	return true
+}
+
+// Add matches values where field equals field in the template.
+func (f *F) Add(field string) *F {
+	if val, ok := f.field(field); ok {
+		f.conds = append(f.conds, condition{
+			field:  field,
+			op:     opEquals,
+			values: []interface{}{val.Interface()},
+		})
+	}
+	return f
+}
+
+// AddPrefix matches values where the string field starts with field in the template.
+func (f *F) AddPrefix(field string) *F {
+	if val, ok := f.field(field); ok {
+		if val.Kind() != reflect.String {
+			f.errs = append(f.errs, fmt.Errorf("%v.%v isn't a string", f.val.Type(), field))
+			return f
+		}
+		f.conds = append(f.conds, condition{
+			field:  field,
+			op:     opPrefix,
+			values: []interface{}{val.String()},
+		})
+	}
+	return f
+}
+
+// AddRange matches values where field is >= from and < to. A nil from or to leaves that end open.
+func (f *F) AddRange(field string, from, to interface{}) *F {
+	if val, ok := f.field(field); ok {
+		if !orderable(val.Type()) {
+			f.errs = append(f.errs, fmt.Errorf("%v.%v isn't orderable", f.val.Type(), field))
+			return f
+		}
+		for _, bound := range []interface{}{from, to} {
+			if bound != nil && !f.checkValue(field, val.Type(), bound) {
+				return f
+			}
+		}
+		f.conds = append(f.conds, condition{
+			field:  field,
+			op:     opRange,
+			values: []interface{}{from, to},
+		})
+	}
+	return f
+}
+
+// AddIn matches values where field equals one of values.
+func (f *F) AddIn(field string, values ...interface{}) *F {
+	if val, ok := f.field(field); ok {
+		for _, value := range values {
+			if !f.checkValue(field, val.Type(), value) {
+				return f
+			}
+		}
+		f.conds = append(f.conds, condition{
+			field:  field,
+			op:     opIn,
+			values: values,
+		})
+	}
+	return f
+}
+
+// Order sorts the found values by field, in descending order if desc is true.
+func (f *F) Order(field string, desc bool) *F {
+	if val, ok := f.field(field); ok {
+		if !orderable(val.Type()) {
+			f.errs = append(f.errs, fmt.Errorf("%v.%v isn't orderable", f.val.Type(), field))
+			return f
+		}
+		f.order = field
+		f.desc = desc
 	}
 	return f
 }
 
+// Limit caps the number of found values, after ordering.
+func (f *F) Limit(n int) *F {
+	f.limit = n
+	return f
+}
+
+func (c *condition) matches(field reflect.Value) bool {
+	switch c.op {
+	case opEquals:
+		return field.Interface() == c.values[0]
+	case opPrefix:
+		return strings.HasPrefix(field.String(), c.values[0].(string))
+	case opRange:
+		if c.values[0] != nil && compare(field, reflect.ValueOf(c.values[0])) < 0 {
+			return false
+		}
+		if c.values[1] != nil && compare(field, reflect.ValueOf(c.values[1])) >= 0 {
+			return false
+		}
+		return true
+	case opIn:
+		for _, value := range c.values {
+			if field.Interface() == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *F) matches(val reflect.Value) bool {
+	for index := range f.conds {
+		field := val.FieldByName(f.conds[index].field)
+		if !field.IsValid() {
+			return false
+		}
+		if !f.conds[index].matches(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// timeRange returns whether c is a range over times, which indexes can answer since times are
+// indexed in sortable form.
+func (c *condition) timeRange() bool {
+	if c.op != opRange || (c.values[0] == nil && c.values[1] == nil) {
+		return false
+	}
+	for _, value := range c.values {
+		if _, isTime := value.(time.Time); value != nil && !isTime {
+			return false
+		}
+	}
+	return true
+}
+
+// indexed returns the first condition that can be answered by one of the indexed fields
+// using one of the given operators.
+func (f *F) indexed(fields []string, ops ...operator) *condition {
+	for index := range f.conds {
+		for _, field := range fields {
+			if f.conds[index].field != field {
+				continue
+			}
+			for _, op := range ops {
+				if f.conds[index].op == op {
+					return &f.conds[index]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// finish sorts and limits the values appended to result after index start.
+func (f *F) finish(result reflect.Value, start int) {
+	found := result.Slice(start, result.Len())
+	if f.order != "" {
+		sort.SliceStable(found.Interface(), func(i, j int) bool {
+			cmp := compare(found.Index(i).FieldByName(f.order), found.Index(j).FieldByName(f.order))
+			if f.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+	if f.limit > 0 && found.Len() > f.limit {
+		result.Set(result.Slice(0, start+f.limit))
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func orderable(typ reflect.Type) bool {
+	if typ == timeType {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func compare(a, b reflect.Value) int {
+	if a.Type() == timeType {
+		ta := a.Interface().(time.Time)
+		tb := b.Interface().(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	}
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch {
+		case a.Int() < b.Int():
+			return -1
+		case a.Int() > b.Int():
+			return 1
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch {
+		case a.Uint() < b.Uint():
+			return -1
+		case a.Uint() > b.Uint():
+			return 1
+		}
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case a.Float() < b.Float():
+			return -1
+		case a.Float() > b.Float():
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortableTime formats times so that they sort like they compare.
+const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+// sortable returns encoded, a JSON value, with times encoded in UTC with fixed width, so that
+// indexed times sort like they compare regardless of time zone.
+func sortable(encoded []byte) []byte {
+	if len(encoded) < 2 || encoded[0] != '"' {
+		return encoded
+	}
+	var s string
+	if err := json.Unmarshal(encoded, &s); err != nil {
+		return encoded
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return encoded
+	}
+	return []byte(fmt.Sprintf("%q", t.UTC().Format(sortableTime)))
+}
+
+// indexKey encodes field values the same way for all backends, so that
+// index lookups match the stored values.
+func indexKey(value interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(sortable(b)), nil
+}
+
+// Index declares that fields of the kind of tmpl will be used to Find it often.
+func (p *Persister) Index(tmpl interface{}, fields ...string) error {
+	tmplType := reflect.TypeOf(tmpl)
+	if tmplType.Kind() != reflect.Struct {
+		return fmt.Errorf("%v isn't a struct", tmplType)
+	}
+	for _, field := range fields {
+		if _, found := tmplType.FieldByName(field); !found {
+			return fmt.Errorf("%v has no field %q", tmplType, field)
+		}
+		if err := p.Backend.Index(tmplType.Name(), field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *Persister) Find(filter *F, result interface{}) error {
 	if len(filter.errs) > 0 {
 		return filter.errs
@@ -86,6 +396,8 @@ func (p *Persister) Transact(f func(*Persister) error) error {
 type Backend interface {
 	Put(kind, key string, value interface{}) error
 	Get(kind, key string, value interface{}) error
+	Del(kind, key string) error
 	Find(kind string, filter *F, result interface{}) error
+	Index(kind, field string) error
 	Transact(func(Backend) error) error
 }
@@ -0,0 +1,222 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testThing struct {
+	Name  string
+	Owner string
+	Count int
+}
+
+func withBackends(t *testing.T, f func(*testing.T, *Persister)) {
+	f(t, &Persister{Backend: NewMem()})
+
+	dir, err := ioutil.TempDir("", "persist_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := NewBolt(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	f(t, &Persister{Backend: b})
+}
+
+func TestGetPutFind(t *testing.T) {
+	withBackends(t, func(t *testing.T, p *Persister) {
+		if err := p.Get("a", &testThing{}); err != ErrNotFound {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+		if err := p.Put("a", &testThing{Name: "a", Owner: "x", Count: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Put("b", &testThing{Name: "b", Owner: "y", Count: 2}); err != nil {
+			t.Fatal(err)
+		}
+		got := &testThing{}
+		if err := p.Get("a", got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "a" || got.Count != 1 {
+			t.Errorf("got %+v", got)
+		}
+		found := []testThing{}
+		if err := p.Find(NewF(testThing{Owner: "y"}).Add("Owner"), &found); err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].Name != "b" {
+			t.Errorf("got %+v", found)
+		}
+	})
+}
+
+func TestTransact(t *testing.T) {
+	withBackends(t, func(t *testing.T, p *Persister) {
+		if err := p.Transact(func(p *Persister) error {
+			return p.Put("a", &testThing{Name: "a"})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Get("a", &testThing{}); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+}
+
+func TestDel(t *testing.T) {
+	withBackends(t, func(t *testing.T, p *Persister) {
+		if err := p.Index(testThing{}, "Owner"); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Put("a", &testThing{Name: "a", Owner: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Del("a", &testThing{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Get("a", &testThing{}); err != ErrNotFound {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+		found := []testThing{}
+		if err := p.Find(NewF(testThing{Owner: "x"}).Add("Owner"), &found); err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 0 {
+			t.Errorf("got %+v, want nothing", found)
+		}
+		if err := p.Del("a", &testThing{}); err != ErrNotFound {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestBoltRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := NewBolt(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	p := &Persister{Backend: b}
+	if err := p.Transact(func(p *Persister) error {
+		if err := p.Put("a", &testThing{Name: "a"}); err != nil {
+			return err
+		}
+		return ErrNotFound
+	}); err != ErrNotFound {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	if err := p.Get("a", &testThing{}); err != ErrNotFound {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	withBackends(t, func(t *testing.T, p *Persister) {
+		if err := p.Index(testThing{}, "Owner"); err != nil {
+			t.Fatal(err)
+		}
+		for index, name := range []string{"apple", "apricot", "banana", "cherry"} {
+			if err := p.Put(name, &testThing{Name: name, Owner: name[:1], Count: index}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := p.Put("cherry", &testThing{Name: "cherry", Owner: "x", Count: 3}); err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			f    *F
+			want []string
+		}{
+			{NewF(testThing{Owner: "a"}).Add("Owner").Order("Name", false), []string{"apple", "apricot"}},
+			{NewF(testThing{Owner: "c"}).Add("Owner"), []string{}},
+			{NewF(testThing{Name: "ap"}).AddPrefix("Name").Order("Count", true), []string{"apricot", "apple"}},
+			{NewF(testThing{}).AddRange("Count", 1, 3).Order("Count", false), []string{"apricot", "banana"}},
+			{NewF(testThing{}).AddIn("Owner", "b", "x").Order("Name", false), []string{"banana", "cherry"}},
+			{NewF(testThing{}).Order("Count", true).Limit(2), []string{"cherry", "banana"}},
+		} {
+			found := []testThing{}
+			if err := p.Find(tc.f, &found); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, thing := range found {
+				names = append(names, thing.Name)
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("got %v, want %v", names, tc.want)
+			}
+		}
+	})
+}
+
+type testEvent struct {
+	Name string
+	At   time.Time
+}
+
+func TestTimeRange(t *testing.T) {
+	withBackends(t, func(t *testing.T, p *Persister) {
+		if err := p.Index(testEvent{}, "At"); err != nil {
+			t.Fatal(err)
+		}
+		base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		east := time.FixedZone("east", 10*3600)
+		west := time.FixedZone("west", -10*3600)
+		for name, at := range map[string]time.Time{
+			"first":  base.In(east),
+			"second": base.Add(time.Hour).In(west),
+			"third":  base.Add(2*time.Hour + time.Millisecond),
+			"fourth": base.Add(3 * time.Hour).In(east),
+		} {
+			if err := p.Put(name, &testEvent{Name: name, At: at}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tc := range []struct {
+			f    *F
+			want []string
+		}{
+			{NewF(testEvent{}).AddRange("At", nil, base.Add(2*time.Hour+time.Millisecond)).Order("At", false), []string{"first", "second"}},
+			{NewF(testEvent{}).AddRange("At", base.Add(time.Hour).In(east), nil).Order("At", false), []string{"second", "third", "fourth"}},
+			{NewF(testEvent{}).AddRange("At", base.Add(time.Minute), base.Add(3*time.Hour)).Order("At", true), []string{"third", "second"}},
+			{NewF(testEvent{At: base.Add(2*time.Hour + time.Millisecond)}).Add("At"), []string{"third"}},
+		} {
+			found := []testEvent{}
+			if err := p.Find(tc.f, &found); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, event := range found {
+				names = append(names, event.Name)
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("got %v, want %v", names, tc.want)
+			}
+		}
+	})
+}
+
+func TestBadFilter(t *testing.T) {
+	p := &Persister{Backend: NewMem()}
+	found := []testThing{}
+	if err := p.Find(NewF(testThing{}).AddRange("Count", "a", nil), &found); err == nil {
+		t.Errorf("wanted error for mismatched range type")
+	}
+	if err := p.Find(NewF(testThing{}).AddPrefix("Count"), &found); err == nil {
+		t.Errorf("wanted error for prefix on int")
+	}
+}
@@ -3,17 +3,54 @@ package resource
 import (
 	"time"
 
+	"github.com/zond/hackyhack/proc/messages"
 	"github.com/zond/hackyhack/server/persist"
 )
 
 type Resource struct {
-	Id        string
-	Owner     string
-	Code      string
-	Container string
-	Content   []string
-	UpdatedAt time.Time
-	CreatedAt time.Time
+	Id            string
+	Owner         string
+	Code          string
+	Container     string
+	Content       []string
+	Permissions   map[string]messages.Permission
+	Subscriptions map[string]messages.Subscription
+	// Broken is set, with the reason in BrokenReason, when the code crash-loops without a good revision to roll back to.
+	Broken       bool
+	BrokenReason string
+	UpdatedAt    time.Time
+	CreatedAt    time.Time
+}
+
+// ownerMethods are only callable by the owner unless the resource gives them a permission of their
+// own, since calling the transform function of others would let attackers mirror it without effort.
+var ownerMethods = map[string]bool{
+	messages.MethodTransform: true,
+}
+
+// Permission returns the permission needed to call method.
+func (r *Resource) Permission(method string) messages.Permission {
+	if perm, found := r.Permissions[method]; found {
+		return perm
+	}
+	if ownerMethods[method] {
+		return messages.PermissionOwner
+	}
+	return r.Permissions[messages.PermissionsDefault]
+}
+
+// Allows returns whether caller may call method.
+func (r *Resource) Allows(caller *Resource, method string) bool {
+	if caller.Id == r.Id || (r.Owner != "" && caller.Owner == r.Owner) {
+		return true
+	}
+	switch r.Permission(method) {
+	case messages.PermissionPublic:
+		return true
+	case messages.PermissionContainer:
+		return r.Container == caller.Id
+	}
+	return false
 }
 
 func (r *Resource) RemoveContent(resource string) {
@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+func TestAllows(t *testing.T) {
+	container := &Resource{Id: "container", Owner: "other"}
+	sibling := &Resource{Id: "sibling", Owner: "other", Container: "container"}
+	owned := &Resource{Id: "owned", Owner: "owner", Container: "elsewhere"}
+
+	for _, tc := range []struct {
+		name   string
+		perms  map[string]messages.Permission
+		caller *Resource
+		method string
+		want   bool
+	}{
+		{"zero value is public", nil, sibling, "Look", true},
+		{"public", map[string]messages.Permission{"Look": messages.PermissionPublic}, sibling, "Look", true},
+		{"owner refuses others", map[string]messages.Permission{"Look": messages.PermissionOwner}, sibling, "Look", false},
+		{"owner allows same owner", map[string]messages.Permission{"Look": messages.PermissionOwner}, owned, "Look", true},
+		{"container allows container", map[string]messages.Permission{"Look": messages.PermissionContainer}, container, "Look", true},
+		{"container refuses siblings", map[string]messages.Permission{"Look": messages.PermissionContainer}, sibling, "Look", false},
+		{"container allows same owner", map[string]messages.Permission{"Look": messages.PermissionContainer}, owned, "Look", true},
+		{"default applies to other methods", map[string]messages.Permission{messages.PermissionsDefault: messages.PermissionOwner}, sibling, "Look", false},
+		{"method overrides default", map[string]messages.Permission{messages.PermissionsDefault: messages.PermissionOwner, "Look": messages.PermissionPublic}, sibling, "Look", true},
+		{"other methods keep default", map[string]messages.Permission{"Look": messages.PermissionOwner}, sibling, "Take", true},
+		{"transform is owner only", map[string]messages.Permission{messages.PermissionsDefault: messages.PermissionPublic}, sibling, messages.MethodTransform, false},
+		{"transform allows same owner", nil, owned, messages.MethodTransform, true},
+		{"transform can be made public", map[string]messages.Permission{messages.MethodTransform: messages.PermissionPublic}, sibling, messages.MethodTransform, true},
+	} {
+		res := &Resource{
+			Id:          "res",
+			Owner:       "owner",
+			Container:   "container",
+			Permissions: tc.perms,
+		}
+		if got := res.Allows(tc.caller, tc.method); got != tc.want {
+			t.Errorf("%v: got %v, wanted %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAllowsSelf(t *testing.T) {
+	res := &Resource{
+		Id: "res",
+		Permissions: map[string]messages.Permission{
+			messages.PermissionsDefault: messages.PermissionOwner,
+		},
+	}
+	if !res.Allows(res, "Look") {
+		t.Errorf("Wanted resources to always allow themselves")
+	}
+	// Resources without owner don't share it with other resources without owner.
+	if res.Allows(&Resource{Id: "other"}, "Look") {
+		t.Errorf("Wanted an empty owner to not count as the same owner")
+	}
+}
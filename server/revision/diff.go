@@ -0,0 +1,47 @@
+package revision
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Diff returns the lines of a and b prefixed with "-" if only in a, "+" if only in b and " " if in both.
+func Diff(a, b string) string {
+	aLines := strings.Split(a, "\n")
+	bLines := strings.Split(b, "\n")
+
+	// common[i][j] is the length of the longest common subsequence of aLines[i:] and bLines[j:].
+	common := make([][]int, len(aLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			buf.WriteString(" " + aLines[i] + "\n")
+			i++
+			j++
+		case j == len(bLines) || (i < len(aLines) && common[i+1][j] >= common[i][j+1]):
+			buf.WriteString("-" + aLines[i] + "\n")
+			i++
+		default:
+			buf.WriteString("+" + bLines[j] + "\n")
+			j++
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,21 @@
+package revision
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	for _, tc := range []struct {
+		a    string
+		b    string
+		want string
+	}{
+		{"a\nb\nc", "a\nb\nc", " a\n b\n c\n"},
+		{"a\nb\nc", "a\nc", " a\n-b\n c\n"},
+		{"a\nc", "a\nb\nc", " a\n+b\n c\n"},
+		{"a\nb", "a\nx", " a\n-b\n+x\n"},
+		{"", "a", "-\n+a\n"},
+	} {
+		if got := Diff(tc.a, tc.b); got != tc.want {
+			t.Errorf("Diff(%q, %q) = %q, want %q", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
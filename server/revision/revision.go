@@ -0,0 +1,124 @@
+package revision
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+)
+
+const (
+	// AuthorRollback is the author of revisions restored because newer code crash-looped.
+	AuthorRollback = "rollback"
+)
+
+// Revision is an immutable copy of code saved for Resource.
+type Revision struct {
+	Id       string
+	Resource string
+	Number   int
+	// Author is the resource of the user, or the resource, that saved Code.
+	Author   string
+	Code     string
+	CodeHash string
+	// Binary is the build cache key of the binary built from Code, if it was built when saved.
+	Binary string
+	// Broken is set when Code has crash-looped.
+	Broken    bool
+	CreatedAt time.Time
+}
+
+func Hash(code string) string {
+	h := sha1.New()
+	io.WriteString(h, code)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func revisionId(resource string, number int) string {
+	return fmt.Sprintf("%v/%d", resource, number)
+}
+
+// Save stores code as the next revision of resource.
+func Save(p *persist.Persister, resource, author, code, binary string) (*Revision, error) {
+	rev := &Revision{
+		Resource:  resource,
+		Author:    author,
+		Code:      code,
+		CodeHash:  Hash(code),
+		Binary:    binary,
+		CreatedAt: time.Now(),
+	}
+	if err := p.Transact(func(p *persist.Persister) error {
+		latest := []Revision{}
+		if err := p.Find(persist.NewF(Revision{
+			Resource: resource,
+		}).Add("Resource").Order("Number", true).Limit(1), &latest); err != nil {
+			return err
+		}
+		rev.Number = 1
+		if len(latest) > 0 {
+			rev.Number = latest[0].Number + 1
+			rev.Broken = latest[0].CodeHash == rev.CodeHash && latest[0].Broken
+		}
+		rev.Id = revisionId(resource, rev.Number)
+		return p.Put(rev.Id, rev)
+	}); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+func Get(p *persist.Persister, resource string, number int) (*Revision, error) {
+	rev := &Revision{}
+	if err := p.Get(revisionId(resource, number), rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// List returns the revisions of resource, newest first.
+func List(p *persist.Persister, resource string) ([]Revision, error) {
+	revs := []Revision{}
+	if err := p.Find(persist.NewF(Revision{
+		Resource: resource,
+	}).Add("Resource").Order("Number", true), &revs); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// MarkBroken marks all revisions of resource with code hash codeHash as broken.
+func MarkBroken(p *persist.Persister, resource, codeHash string) error {
+	return p.Transact(func(p *persist.Persister) error {
+		revs, err := List(p, resource)
+		if err != nil {
+			return err
+		}
+		for index := range revs {
+			if revs[index].CodeHash == codeHash && !revs[index].Broken {
+				revs[index].Broken = true
+				if err := p.Put(revs[index].Id, &revs[index]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// LastGood returns the newest revision of resource not marked broken, or persist.ErrNotFound.
+func LastGood(p *persist.Persister, resource string) (*Revision, error) {
+	revs, err := List(p, resource)
+	if err != nil {
+		return nil, err
+	}
+	for index := range revs {
+		if !revs[index].Broken {
+			return &revs[index], nil
+		}
+	}
+	return nil, persist.ErrNotFound
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"time"
+
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/server/account"
+)
+
+const (
+	accountingInterval = time.Second * 10
+)
+
+func (r *Router) account() {
+	for range time.Tick(accountingInterval) {
+		r.chargeOwners()
+	}
+}
+
+// chargeOwners charges the CPU used since the last call to the owners of the running MCPs,
+// suspends the MCPs of owners over their CPU quota, and kills the largest MCP of owners over
+// their memory quota.
+// Only called from the account goroutine, so chargedCPU needs no locking.
+func (r *Router) chargeOwners() {
+	byOwner := map[string][]*mcp.MCP{}
+	r.handlerLock.RLock()
+	for oc, m := range r.handlerByOwnerCode {
+		byOwner[oc.owner] = append(byOwner[oc.owner], m)
+	}
+	r.handlerLock.RUnlock()
+
+	charged := map[*mcp.MCP]time.Duration{}
+	for owner, ms := range byOwner {
+		var cpu time.Duration
+		var rss int64
+		var largest *mcp.MCP
+		var largestRSS int64
+		for _, m := range ms {
+			total, mrss := m.Usage()
+			if delta := total - r.chargedCPU[m]; delta > 0 {
+				cpu += delta
+			}
+			charged[m] = total
+			rss += mrss
+			if mrss > largestRSS {
+				largest, largestRSS = m, mrss
+			}
+		}
+		// Resources without owner, like the void, belong to the house.
+		if owner == "" {
+			continue
+		}
+		acc, err := account.Charge(r.persister, owner, cpu, rss)
+		if err != nil {
+			r.debugHandler("*** FAILED CHARGING %q: %v ***", owner, err)
+			continue
+		}
+		for _, m := range ms {
+			if m.Suspended() != acc.Suspended {
+				if err := m.Suspend(acc.Suspended); err != nil {
+					r.debugHandler("*** FAILED SUSPENDING MCP OF %q: %v ***", owner, err)
+				}
+			}
+		}
+		// Killing the largest is usually enough, and if not the next round kills the next largest.
+		if acc.OverRSS() && largest != nil {
+			if err := largest.Kill(); err != nil {
+				r.debugHandler("*** FAILED KILLING MCP OF %q: %v ***", owner, err)
+			}
+		}
+	}
+	r.chargedCPU = charged
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/challenge"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+)
+
+func (w *resourceWrapper) Challenge(target string) (bool, *messages.Error) {
+	return w.router.challenge(w.resource, target)
+}
+
+// transform calls the transform function of resourceId with input on behalf of source.
+func (r *Router) transform(source, resourceId, input string) (string, error) {
+	m, err := r.MCP(resourceId)
+	if err != nil {
+		return "", err
+	}
+	var output string
+	var merr *messages.Error
+	if err := m.Call(source, resourceId, messages.MethodTransform, []interface{}{input}, &[]interface{}{&output, &merr}); err != nil {
+		return "", err
+	}
+	return output, merr.ToErr()
+}
+
+// challenge lets attacker try to pwn target by calling the transform function of attacker with a
+// random input, and the transform function of target with the output of attacker. If target turns
+// it back into the input, target will be owned by the owner of attacker.
+// The attempt is recorded, and the container of attacker told about it.
+// Since transform functions are only callable by their owners, unless the owner allows more,
+// attacker can't just ask target what its transform does.
+func (r *Router) challenge(attacker, target string) (bool, *messages.Error) {
+	attackerRes, merr := r.getResource(attacker)
+	if merr != nil {
+		return false, merr
+	}
+	targetRes, merr := r.getResource(target)
+	if merr != nil {
+		return false, merr
+	}
+	if target == attacker || (targetRes.Container != attacker && (targetRes.Container == "" || targetRes.Container != attackerRes.Container)) {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("%q isn't here.", target),
+			Code:    messages.ErrorCodeNotAdjacent,
+		}
+	}
+	if targetRes.Owner == attackerRes.Owner {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("%q is already yours.", target),
+			Code:    messages.ErrorCodeRefused,
+		}
+	}
+	// Avatars own themselves, and losing them would lock their users out.
+	if target == messages.VoidResource || targetRes.Owner == targetRes.Id {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("%q can't be pwned.", target),
+			Code:    messages.ErrorCodeRefused,
+		}
+	}
+
+	c := &challenge.Challenge{
+		Attacker:      attacker,
+		AttackerOwner: attackerRes.Owner,
+		Target:        target,
+		TargetOwner:   targetRes.Owner,
+		Input:         fmt.Sprintf("%x", rand.Int63()),
+	}
+	var err error
+	if c.AttackerOutput, err = r.transform(attacker, attacker, c.Input); mcp.IsNoSuchMethod(err) {
+		return false, &messages.Error{
+			Message: "You have no transform function.",
+			Code:    messages.ErrorCodeRefused,
+		}
+	} else if err != nil {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("Unable to transform: %v", err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+	if c.TargetOutput, err = r.transform(attacker, target, c.AttackerOutput); mcp.IsNoSuchMethod(err) {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("%q can't be pwned.", target),
+			Code:    messages.ErrorCodeRefused,
+		}
+	} else if err != nil {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("Unable to transform %q: %v", target, err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+	c.Success = c.TargetOutput == c.Input
+
+	ev := &messages.Event{
+		Type:   messages.EventTypeChallenge,
+		Source: attacker,
+		Object: target,
+		Metadata: map[string]string{
+			messages.MetadataPwned: fmt.Sprint(c.Success),
+		},
+	}
+	if ev.SourceShortDesc, merr = r.describe(attacker); merr != nil {
+		return false, merr
+	}
+	if ev.ObjectShortDesc, merr = r.describe(target); merr != nil {
+		return false, merr
+	}
+
+	if err := r.persister.Transact(func(p *persist.Persister) error {
+		if c.Success {
+			res := &resource.Resource{}
+			if err := p.Get(target, res); err != nil {
+				return err
+			}
+			if res.Owner != c.TargetOwner {
+				return fmt.Errorf("%q changed owner during the challenge", target)
+			}
+			res.Owner = c.AttackerOwner
+			if err := p.Put(target, res); err != nil {
+				return err
+			}
+		}
+		return c.Save(p)
+	}); err != nil {
+		return false, &messages.Error{
+			Message: fmt.Sprintf("Recording challenge failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+
+	if c.Success {
+		// The new owner pays for running the target from now on.
+		if err := r.Restart(target); err != nil {
+			return false, &messages.Error{
+				Message: fmt.Sprintf("Restarting %q failed: %v", target, err),
+				Code:    messages.ErrorCodeProxyFailed,
+			}
+		}
+	}
+
+	go r.Broadcast(attackerRes.Container, ev)
+
+	return c.Success, nil
+}
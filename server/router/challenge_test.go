@@ -0,0 +1,126 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/challenge"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+)
+
+// transformer is code for a resource that transforms its input with the body of transformBody.
+const transformer = `package main
+
+import (
+	"github.com/zond/hackyhack/proc/interfaces"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/proc/slave"
+)
+
+type handler struct {
+	m interfaces.MCP
+}
+
+func New(m interfaces.MCP) interfaces.Describable {
+	return &handler{
+		m: m,
+	}
+}
+
+func (h *handler) GetShortDesc() (*messages.ShortDesc, *messages.Error) {
+	return &messages.ShortDesc{
+		Value: h.m.GetResource(),
+	}, nil
+}
+
+func (h *handler) GetLongDesc() (string, *messages.Error) {
+	return "", nil
+}
+
+func (h *handler) Transform(s string) (string, *messages.Error) {
+	transformBody
+}
+
+func main() {
+	slave.Register(New)
+}
+`
+
+const (
+	rotateLeft  = "return s[1:] + s[:1], nil"
+	rotateRight = "return s[len(s)-1:] + s[:len(s)-1], nil"
+	reverse     = `b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b), nil`
+	// proxy asks the target to undo itself, which would succeed against every involution.
+	proxy = `var out string
+	var merr *messages.Error
+	if err := h.m.Call(nil, "target", messages.MethodTransform, []string{s}, &[]interface{}{&out, &merr}); err != nil {
+		return "", err
+	}
+	return out, merr`
+)
+
+func transformCode(body string) string {
+	return strings.Replace(transformer, "transformBody", body, 1)
+}
+
+func TestChallenge(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		attacker  string
+		target    string
+		wantPwned bool
+		proxied   bool
+	}{
+		{"mirror", rotateRight, rotateLeft, true, false},
+		{"no mirror", rotateLeft, rotateLeft, false, false},
+		{"proxy", proxy, reverse, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRouter(t)
+			putResource(t, r, "attacker", "attackerOwner", transformCode(tc.attacker), messages.VoidResource)
+			putResource(t, r, "target", "targetOwner", transformCode(tc.target), messages.VoidResource)
+
+			pwned, merr := r.challenge("attacker", "target")
+			if tc.proxied {
+				if merr == nil && pwned {
+					t.Errorf("Got pwned, wanted the proxied transform refused")
+				}
+			} else if merr != nil {
+				t.Fatal(merr.ToErr())
+			} else if pwned != tc.wantPwned {
+				t.Errorf("Got pwned %v, wanted %v", pwned, tc.wantPwned)
+			}
+
+			target := &resource.Resource{}
+			if err := r.persister.Get("target", target); err != nil {
+				t.Fatal(err)
+			}
+			wantOwner := "targetOwner"
+			if tc.wantPwned {
+				wantOwner = "attackerOwner"
+			}
+			if target.Owner != wantOwner {
+				t.Errorf("Got owner %q, wanted %q", target.Owner, wantOwner)
+			}
+
+			if tc.proxied {
+				return
+			}
+			challenges := []challenge.Challenge{}
+			if err := r.persister.Find(persist.NewF(challenge.Challenge{
+				Target: "target",
+			}).Add("Target"), &challenges); err != nil {
+				t.Fatal(err)
+			}
+			if len(challenges) != 1 || challenges[0].Success != tc.wantPwned {
+				t.Errorf("Got challenges %+v, wanted one with success %v", challenges, tc.wantPwned)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/telnet"
+)
+
+const (
+	maxGMCPPayload = 1 << 16
+)
+
+// gmcpPackageReg matches GMCP package and message names, like Room.Info or Char.Items.List.
+var gmcpPackageReg = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*(\.[A-Za-z][A-Za-z0-9_-]*)*$`)
+
+// SendGMCP sends payload, which has to be JSON, to the client controlling the resource as the GMCP
+// message pkg.
+func (w *clientWrapper) SendGMCP(pkg, payload string) *messages.Error {
+	if !gmcpPackageReg.MatchString(pkg) {
+		return &messages.Error{
+			Message: fmt.Sprintf("%q isn't a GMCP package name.", pkg),
+			Code:    messages.ErrorCodeRefused,
+		}
+	}
+	if len(payload) > maxGMCPPayload || !json.Valid([]byte(payload)) {
+		return &messages.Error{
+			Message: fmt.Sprintf("GMCP payloads have to be JSON of at most %v bytes.", maxGMCPPayload),
+			Code:    messages.ErrorCodeRefused,
+		}
+	}
+	if err := w.client.SendGMCP(pkg, []byte(payload)); err == telnet.ErrGMCPDisabled {
+		return &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeNoClient,
+		}
+	} else if err != nil {
+		w.resourceWrapper.router.UnregisterClient(w.resourceWrapper.resource)
+		return &messages.Error{
+			Message: fmt.Sprintf("client.SendGMCP failed: %v", err),
+			Code:    messages.ErrorCodeSendToClient,
+		}
+	}
+	return nil
+}
+
+// SendGMCP is only found for resources without client, since clientWrapper shadows it.
+func (w *resourceWrapper) SendGMCP(pkg, payload string) *messages.Error {
+	return &messages.Error{
+		Message: "No client connected.",
+		Code:    messages.ErrorCodeNoClient,
+	}
+}
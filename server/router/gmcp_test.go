@@ -0,0 +1,94 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/telnet"
+)
+
+type gmcpSender interface {
+	SendGMCP(pkg, payload string) *messages.Error
+}
+
+// selfSender returns what the code of id finds when it sends GMCP to itself.
+func selfSender(t *testing.T, r *Router, id string) gmcpSender {
+	found, err := r.findResource(id, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, ok := found[0].(gmcpSender)
+	if !ok {
+		t.Fatalf("Got %+v, wanted something sending GMCP first", found)
+	}
+	return sender
+}
+
+func TestSendGMCP(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "player", "owner", initialVoid, messages.VoidResource)
+	putResource(t, r, "lonely", "owner", initialVoid, messages.VoidResource)
+	client := newTestClient()
+	r.RegisterClient("player", client)
+
+	if merr := selfSender(t, r, "lonely").SendGMCP("Room.Info", "{}"); errorCode(merr) != messages.ErrorCodeNoClient {
+		t.Errorf("Got %+v, wanted error code %v without client", merr, messages.ErrorCodeNoClient)
+	}
+
+	for _, tc := range []struct {
+		pkg     string
+		payload string
+		want    messages.ErrorCode
+	}{
+		{"Room.Info", `{"num": 1}`, noError},
+		{"Char.Items.List", `[]`, noError},
+		{"", "{}", messages.ErrorCodeRefused},
+		{"Room.", "{}", messages.ErrorCodeRefused},
+		{"1Room", "{}", messages.ErrorCodeRefused},
+		{"Room Info", "{}", messages.ErrorCodeRefused},
+		{"Room.Info\xff", "{}", messages.ErrorCodeRefused},
+		{"Room.Info", "{", messages.ErrorCodeRefused},
+		{"Room.Info", "not json", messages.ErrorCodeRefused},
+		{"Room.Info", fmt.Sprintf("%q", strings.Repeat("x", maxGMCPPayload)), messages.ErrorCodeRefused},
+	} {
+		merr := selfSender(t, r, "player").SendGMCP(tc.pkg, tc.payload)
+		if got := errorCode(merr); got != tc.want {
+			t.Errorf("SendGMCP(%q, %.20q) got %+v, wanted error code %v", tc.pkg, tc.payload, merr, tc.want)
+			continue
+		}
+		if tc.want != noError {
+			continue
+		}
+		if got, want := await(t, client.gmcp), tc.pkg+" "+tc.payload; got != want {
+			t.Errorf("Got %q sent, wanted %q", got, want)
+		}
+	}
+	select {
+	case sent := <-client.gmcp:
+		t.Errorf("Got %q sent, wanted refused messages kept from the client", sent)
+	default:
+	}
+
+	// Clients without GMCP are like no client, and stay connected.
+	client.gmcpErr = telnet.ErrGMCPDisabled
+	if merr := selfSender(t, r, "player").SendGMCP("Room.Info", "{}"); errorCode(merr) != messages.ErrorCodeNoClient {
+		t.Errorf("Got %+v, wanted error code %v for a client without GMCP", merr, messages.ErrorCodeNoClient)
+	}
+	r.clientLock.RLock()
+	_, found := r.clients["player"]
+	r.clientLock.RUnlock()
+	if !found {
+		t.Errorf("Wanted the client without GMCP still registered")
+	}
+
+	// Clients failing to send are gone.
+	client.gmcpErr = fmt.Errorf("connection reset")
+	if merr := selfSender(t, r, "player").SendGMCP("Room.Info", "{}"); errorCode(merr) != messages.ErrorCodeSendToClient {
+		t.Errorf("Got %+v, wanted error code %v for a failing client", merr, messages.ErrorCodeSendToClient)
+	}
+	if merr := selfSender(t, r, "player").SendGMCP("Room.Info", "{}"); errorCode(merr) != messages.ErrorCodeNoClient {
+		t.Errorf("Got %+v, wanted error code %v after the client failed", merr, messages.ErrorCodeNoClient)
+	}
+}
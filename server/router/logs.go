@@ -0,0 +1,94 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/logs"
+)
+
+func (w *resourceWrapper) Log(text string) *messages.Error {
+	w.router.logs.Append(w.resource, logs.SourceLog, text[:cut(text)])
+	return nil
+}
+
+// GetLogs returns the logs of resourceId, if it has the same owner as the calling resource.
+func (w *resourceWrapper) GetLogs(resourceId string) ([]messages.LogLine, *messages.Error) {
+	caller, merr := w.router.getResource(w.resource)
+	if merr != nil {
+		return nil, merr
+	}
+	res, merr := w.router.getResource(resourceId)
+	if merr != nil {
+		return nil, merr
+	}
+	if caller.Owner != res.Owner {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("Resource %q isn't yours.", resourceId),
+			Code:    messages.ErrorCodeNotOwner,
+		}
+	}
+	return w.router.logs.Lines(resourceId), nil
+}
+
+func (r *Router) Logs() *logs.Buffers {
+	return r.logs
+}
+
+// cut returns the length of the longest prefix of text that fits in a log line without splitting a rune.
+func cut(text string) int {
+	if len(text) <= logs.MaxLine {
+		return len(text)
+	}
+	for n := logs.MaxLine; n > 0; n-- {
+		if utf8.RuneStart(text[n]) {
+			return n
+		}
+	}
+	return logs.MaxLine
+}
+
+// stderrHandler returns a function that splits the stderr of the MCP running the code of oc
+// into lines, and logs them for all resources the MCP runs, since there's no telling which one wrote them.
+// Lines longer than logs.MaxLine are split, so that a child never writing newlines can't grow the buffer.
+func (r *Router) stderrHandler(oc ownerCode) func([]byte) {
+	partial := &bytes.Buffer{}
+	return func(b []byte) {
+		partial.Write(b)
+		for {
+			line, err := partial.ReadString('\n')
+			if err != nil {
+				// No newline yet, so keep the rest for the next write.
+				partial.Reset()
+				partial.WriteString(r.logFull(oc, line))
+				return
+			}
+			r.logStderr(oc, r.logFull(oc, strings.TrimRight(line, "\n")))
+		}
+	}
+}
+
+// logFull logs the parts of text filling whole log lines for oc, and returns the rest.
+func (r *Router) logFull(oc ownerCode, text string) string {
+	for len(text) > logs.MaxLine {
+		n := cut(text)
+		r.logStderr(oc, text[:n])
+		text = text[n:]
+	}
+	return text
+}
+
+// logStderr logs line for all resources the MCP running the code of oc runs.
+func (r *Router) logStderr(oc ownerCode, line string) {
+	r.debugHandler("STDERR: %q", line)
+	r.handlerLock.RLock()
+	defer r.handlerLock.RUnlock()
+	for id, hd := range r.handlerDataByResource {
+		if hd.oc == oc {
+			r.logs.Append(id, logs.SourceStderr, line)
+		}
+	}
+}
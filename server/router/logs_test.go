@@ -0,0 +1,70 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/logs"
+)
+
+func TestRestartKeepsLogs(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "res", "owner", initialVoid, messages.VoidResource)
+	if _, err := r.MCP("res"); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Decomission("res")
+	w := &resourceWrapper{
+		router:   r,
+		resource: "res",
+	}
+	w.Log("before crash")
+	if err := r.Restart("res"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := r.logs.Lines("res"); len(lines) != 1 || lines[0].Text != "before crash" {
+		t.Errorf("Got %+v, wanted the logs kept across the restart", lines)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "res", "owner", initialVoid, messages.VoidResource)
+	if _, err := r.MCP("res"); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Decomission("res")
+	w := &resourceWrapper{
+		router:   r,
+		resource: "res",
+	}
+	w.Log(strings.Repeat("l", logs.MaxLine+1))
+
+	r.handlerLock.RLock()
+	oc := r.handlerDataByResource["res"].oc
+	r.handlerLock.RUnlock()
+	stderr := r.stderrHandler(oc)
+	// Without newlines, full lines are logged as they fill up.
+	stderr([]byte(strings.Repeat("a", logs.MaxLine)))
+	stderr([]byte(strings.Repeat("b", logs.MaxLine+10)))
+	// Runes aren't split between lines.
+	stderr([]byte(strings.Repeat("c", logs.MaxLine-10-1) + "åäö\n"))
+
+	want := []string{
+		strings.Repeat("l", logs.MaxLine),
+		strings.Repeat("a", logs.MaxLine),
+		strings.Repeat("b", logs.MaxLine),
+		strings.Repeat("b", 10) + strings.Repeat("c", logs.MaxLine-10-1),
+		"åäö",
+	}
+	lines := r.logs.Lines("res")
+	if len(lines) != len(want) {
+		t.Fatalf("Got %v lines, wanted %v", len(lines), len(want))
+	}
+	for index, line := range lines {
+		if line.Text != want[index] {
+			t.Errorf("Got line %v of %v bytes, wanted %v bytes", index, len(line.Text), len(want[index]))
+		}
+	}
+}
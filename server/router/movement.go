@@ -0,0 +1,212 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/user"
+)
+
+func (w *resourceWrapper) Move(destination string) *messages.Error {
+	return w.router.move(w.resource, destination)
+}
+
+func (r *Router) shortDesc(resourceId string) (*messages.ShortDesc, error) {
+	m, err := r.MCP(resourceId)
+	if err != nil {
+		return nil, err
+	}
+	var sd *messages.ShortDesc
+	var merr *messages.Error
+	if err := m.Call(resourceId, resourceId, messages.MethodGetShortDesc, nil, &[]interface{}{&sd, &merr}); err != nil {
+		return nil, err
+	}
+	if merr != nil {
+		return nil, merr.ToErr()
+	}
+	return sd, nil
+}
+
+// exits returns the exits the code of the container declares, or none if it doesn't implement GetExits.
+func (r *Router) exits(container string) (map[string]string, error) {
+	m, err := r.MCP(container)
+	if err != nil {
+		return nil, err
+	}
+	var exits map[string]string
+	var merr *messages.Error
+	if err := m.Call(container, container, messages.MethodGetExits, nil, &[]interface{}{&exits, &merr}); mcp.IsNoSuchMethod(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return exits, merr.ToErr()
+}
+
+func exitTo(exits map[string]string, destination string) (string, bool) {
+	for name, id := range exits {
+		if id == destination {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// contains returns whether id is ancestor, or somewhere inside ancestor.
+func (r *Router) contains(ancestor, id string) (bool, error) {
+	for id != "" {
+		if id == ancestor {
+			return true, nil
+		}
+		res := &resource.Resource{}
+		if err := r.persister.Get(id, res); err != nil {
+			return false, err
+		}
+		id = res.Container
+	}
+	return false, nil
+}
+
+// errMovedMeanwhile is returned by relocate when the resource has left the container it was validated in.
+var errMovedMeanwhile = fmt.Errorf("Resource moved meanwhile")
+
+// relocate moves resourceId from origin to destination, and makes sure users log in where their
+// resources were left. It returns errMovedMeanwhile if resourceId is no longer in origin, since the
+// move was only validated for that.
+func (r *Router) relocate(resourceId, origin, destination string) error {
+	return r.persister.Transact(func(p *persist.Persister) error {
+		res := &resource.Resource{}
+		if err := p.Get(resourceId, res); err != nil {
+			return err
+		}
+		if res.Container != origin {
+			return errMovedMeanwhile
+		}
+		if err := res.MoveTo(p, destination); err != nil {
+			return err
+		}
+		users := []user.User{}
+		if err := p.Find(persist.NewF(user.User{
+			Resource: res.Id,
+		}).Add("Resource"), &users); err != nil {
+			return err
+		}
+		for index := range users {
+			users[index].Container = destination
+			if err := p.Put(users[index].Username, &users[index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// move moves resourceId, and implicitly everything inside it, to destination, which has
+// to be one of the exits of its container or the container of its container.
+func (r *Router) move(resourceId, destination string) *messages.Error {
+	res := &resource.Resource{}
+	if err := r.persister.Get(resourceId, res); err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	if res.Container == "" {
+		return &messages.Error{
+			Message: "Can't move from nowhere.",
+			Code:    messages.ErrorCodeNotAdjacent,
+		}
+	}
+	origin := &resource.Resource{}
+	if err := r.persister.Get(res.Container, origin); err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+
+	exits, err := r.exits(origin.Id)
+	if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("Unable to find exits: %v", err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+	exit, found := exitTo(exits, destination)
+	if !found {
+		if destination == "" || destination != origin.Container {
+			return &messages.Error{
+				Message: fmt.Sprintf("%q isn't adjacent.", destination),
+				Code:    messages.ErrorCodeNotAdjacent,
+			}
+		}
+		exit = "out"
+	}
+
+	cyclic, err := r.contains(resourceId, destination)
+	if err == persist.ErrNotFound {
+		return &messages.Error{
+			Message: fmt.Sprintf("No resource %q found.", destination),
+			Code:    messages.ErrorCodeNoSuchResource,
+		}
+	} else if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	if cyclic {
+		return &messages.Error{
+			Message: "Can't move into yourself.",
+			Code:    messages.ErrorCodeNotAdjacent,
+		}
+	}
+
+	sd, err := r.shortDesc(resourceId)
+	if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("Unable to describe mover: %v", err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+
+	if err := r.relocate(resourceId, origin.Id, destination); err == errMovedMeanwhile {
+		return &messages.Error{
+			Message: "Moved elsewhere meanwhile.",
+			Code:    messages.ErrorCodeRefused,
+		}
+	} else if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("Moving failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+
+	// Broadcast delivers to whatever is in the container when it runs, so the mover
+	// will hear about itself arriving but not about itself leaving.
+	go r.Broadcast(origin.Id, &messages.Event{
+		Type:            messages.EventTypeLeave,
+		Source:          resourceId,
+		SourceShortDesc: sd,
+		Metadata: map[string]string{
+			messages.MetadataExit: exit,
+		},
+	})
+	arrival := &messages.Event{
+		Type:            messages.EventTypeArrive,
+		Source:          resourceId,
+		SourceShortDesc: sd,
+		Metadata:        map[string]string{},
+	}
+	if backExits, err := r.exits(destination); err != nil {
+		r.debugHandler("*** UNABLE TO FIND EXITS OF %q: %v ***", destination, err)
+	} else if back, found := exitTo(backExits, origin.Id); found {
+		arrival.Metadata[messages.MetadataExit] = back
+	}
+	go r.Broadcast(destination, arrival)
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+// world is code for rooms a and b with exits to each other, and things that only refuse to be
+// moved if they are heavy.
+var world = codeWith("", `func (h *handler) GetExits() (map[string]string, *messages.Error) {
+	switch h.m.GetResource() {
+	case "a":
+		return map[string]string{"north": "b"}, nil
+	case "b":
+		return map[string]string{"south": "a"}, nil
+	}
+	return nil, nil
+}
+
+func (h *handler) AllowMove(transfer *messages.Transfer) (bool, *messages.Error) {
+	return transfer.Resource != "heavy" && transfer.Resource != "carriedHeavy", nil
+}`)
+
+// noError is what errorCode returns for no error, since the zero ErrorCode is ErrorCodeUnknown.
+const noError messages.ErrorCode = -1
+
+func errorCode(merr *messages.Error) messages.ErrorCode {
+	if merr == nil {
+		return noError
+	}
+	return merr.Code
+}
+
+func TestMove(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "a", "owner", world, messages.VoidResource)
+	putResource(t, r, "b", "owner", world, messages.VoidResource)
+	putResource(t, r, "mover", "owner", world, "a")
+
+	for _, step := range []struct {
+		name        string
+		destination string
+		wantCode    messages.ErrorCode
+		wantIn      string
+	}{
+		{"not adjacent", "c", messages.ErrorCodeNotAdjacent, "a"},
+		{"via exit", "b", noError, "b"},
+		{"back via exit", "a", noError, "a"},
+		{"to the outer container", messages.VoidResource, noError, messages.VoidResource},
+		{"without exits or outer container", "a", messages.ErrorCodeNotAdjacent, messages.VoidResource},
+	} {
+		if merr := r.move("mover", step.destination); errorCode(merr) != step.wantCode {
+			t.Errorf("%v: got %v, wanted error code %v", step.name, merr, step.wantCode)
+		}
+		if got := containerOf(t, r, "mover"); got != step.wantIn {
+			t.Errorf("%v: got mover in %q, wanted %q", step.name, got, step.wantIn)
+		}
+	}
+}
+
+func TestRelocateMovedMeanwhile(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "a", "owner", world, messages.VoidResource)
+	putResource(t, r, "b", "owner", world, messages.VoidResource)
+	putResource(t, r, "item", "owner", world, "b")
+	// Validated while item was in a, but someone moved it to b before relocating.
+	if err := r.relocate("item", "a", messages.VoidResource); err != errMovedMeanwhile {
+		t.Errorf("Got %v, wanted %v", err, errMovedMeanwhile)
+	}
+	if got := containerOf(t, r, "item"); got != "b" {
+		t.Errorf("Got item in %q, wanted it left in b", got)
+	}
+	containerOf(t, r, "b")
+}
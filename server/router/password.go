@@ -0,0 +1,36 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/credentials"
+)
+
+// ChangePassword changes the password of the user controlling the resource.
+func (w *resourceWrapper) ChangePassword(oldPassword, newPassword string) *messages.Error {
+	switch err := credentials.ChangePassword(w.router.persister, w.resource, oldPassword, newPassword); err {
+	case nil:
+		return nil
+	case credentials.ErrUnknownUser:
+		return &messages.Error{
+			Message: "Only avatars have passwords.",
+			Code:    messages.ErrorCodeNotOwner,
+		}
+	case credentials.ErrWrongPassword:
+		return &messages.Error{
+			Message: "Incorrect password.",
+			Code:    messages.ErrorCodePermissionDenied,
+		}
+	case credentials.ErrPasswordSize:
+		return &messages.Error{
+			Message: fmt.Sprintf("%v.", err),
+			Code:    messages.ErrorCodeRefused,
+		}
+	default:
+		return &messages.Error{
+			Message: fmt.Sprintf("Changing password failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/resource"
+)
+
+const (
+	maxPropagationDepth = 3
+	maxPropagationUp    = 2
+	maxPropagationExits = 2
+)
+
+func limit(i, max int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+// audience returns the resources that hear an event happening in container, and how attenuated
+// they hear it. Without propagation only the content of container hears it.
+func (r *Router) audience(container string, propagation *messages.Propagation) (map[string]int, error) {
+	prop := messages.Propagation{}
+	if propagation != nil {
+		prop = *propagation
+	}
+	prop.Depth = limit(prop.Depth, maxPropagationDepth)
+	prop.Up = limit(prop.Up, maxPropagationUp)
+	prop.Exits = limit(prop.Exits, maxPropagationExits)
+
+	rooms := map[string]int{container: 0}
+	roomOrder := []string{container}
+
+	cont := &resource.Resource{}
+	if err := r.persister.Get(container, cont); err != nil {
+		return nil, err
+	}
+	for level, outer := 1, cont; level <= prop.Up && outer.Container != ""; level++ {
+		id := outer.Container
+		outer = &resource.Resource{}
+		if err := r.persister.Get(id, outer); err != nil {
+			r.debugHandler("*** MISSING CONTAINER %q WHEN PROPAGATING: %v ***", id, err)
+			break
+		}
+		rooms[id] = level
+		roomOrder = append(roomOrder, id)
+	}
+
+	frontier := []string{container}
+	for distance := 1; distance <= prop.Exits; distance++ {
+		next := []string{}
+		for _, room := range frontier {
+			exits, err := r.exits(room)
+			if err != nil {
+				r.debugHandler("*** UNABLE TO FIND EXITS OF %q WHEN PROPAGATING: %v ***", room, err)
+				continue
+			}
+			for _, destination := range exits {
+				if _, found := rooms[destination]; !found {
+					rooms[destination] = distance
+					roomOrder = append(roomOrder, destination)
+					next = append(next, destination)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	result := map[string]int{}
+	for _, room := range roomOrder {
+		r.addContent(result, room, rooms[room], prop.Depth)
+	}
+	return result, nil
+}
+
+// addContent adds the content of container, and depth levels of their content, to audience
+// unless they already hear the event better.
+func (r *Router) addContent(audience map[string]int, container string, attenuation, depth int) {
+	cont := &resource.Resource{}
+	if err := r.persister.Get(container, cont); err != nil {
+		r.debugHandler("*** MISSING CONTAINER %q WHEN PROPAGATING: %v ***", container, err)
+		return
+	}
+	for _, id := range cont.Content {
+		if previous, found := audience[id]; found && previous <= attenuation {
+			continue
+		}
+		audience[id] = attenuation
+		if depth > 0 {
+			r.addContent(audience, id, attenuation, depth-1)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+// hearer is code for a resource telling its client about events like avatars do.
+var hearer = codeWith(`"github.com/zond/hackyhack/client/events"`, `func (h *handler) Event(ctx *messages.Context, ev *messages.Event) bool {
+	return (&events.DefaultHandler{M: h.m}).Event(ctx, ev)
+}`)
+
+// propagationWorld builds rooms a and b in the void with an exit between them, a player with a ring
+// in a pocket in a, and a neighbour with a bag in b.
+func propagationWorld(t *testing.T, r *Router) {
+	putResource(t, r, "a", "owner", world, messages.VoidResource)
+	putResource(t, r, "b", "owner", world, messages.VoidResource)
+	putResource(t, r, "speaker", "owner", world, "a")
+	putResource(t, r, "player", "owner", world, "a")
+	putResource(t, r, "pocket", "owner", world, "player")
+	putResource(t, r, "ring", "owner", world, "pocket")
+	putResource(t, r, "neighbour", "owner", world, "b")
+	putResource(t, r, "bag", "owner", world, "neighbour")
+}
+
+func TestAudience(t *testing.T) {
+	r := testRouter(t)
+	propagationWorld(t, r)
+
+	for _, tc := range []struct {
+		name        string
+		propagation *messages.Propagation
+		want        map[string]int
+	}{
+		{"content only", nil, map[string]int{"speaker": 0, "player": 0}},
+		{"nested content", &messages.Propagation{Depth: 1}, map[string]int{"speaker": 0, "player": 0, "pocket": 0}},
+		{"deeply nested content", &messages.Propagation{Depth: 2}, map[string]int{"speaker": 0, "player": 0, "pocket": 0, "ring": 0}},
+		{"limited depth", &messages.Propagation{Depth: 100}, map[string]int{"speaker": 0, "player": 0, "pocket": 0, "ring": 0}},
+		{"outer container", &messages.Propagation{Up: 1}, map[string]int{"speaker": 0, "player": 0, "a": 1, "b": 1}},
+		{"across exits", &messages.Propagation{Exits: 1}, map[string]int{"speaker": 0, "player": 0, "neighbour": 1}},
+		{"nested across exits", &messages.Propagation{Depth: 1, Exits: 1}, map[string]int{"speaker": 0, "player": 0, "pocket": 0, "neighbour": 1, "bag": 1}},
+	} {
+		got, err := r.audience("a", tc.propagation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %v, wanted %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAttenuation(t *testing.T) {
+	r := testRouter(t)
+	propagationWorld(t, r)
+	putResource(t, r, "hearer", "owner", hearer, "b")
+	if _, err := r.MCP("hearer"); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient()
+	r.RegisterClient("hearer", client)
+	w := &resourceWrapper{
+		router:   r,
+		resource: "hearer",
+	}
+	if _, merr := w.Subscribe(&messages.Subscription{
+		EventTypeReg: "^Say$",
+		HandlerName:  "Event",
+	}); merr != nil {
+		t.Fatal(merr.ToErr())
+	}
+
+	say := func(source string, propagation *messages.Propagation) *messages.Event {
+		return &messages.Event{
+			Type:   messages.EventTypeSay,
+			Source: source,
+			Metadata: map[string]string{
+				messages.MetadataPayload: "hello",
+			},
+			Propagation: propagation,
+		}
+	}
+	r.Broadcast("a", say("speaker", &messages.Propagation{Exits: 1}))
+	if got, want := await(t, client.sent), "You hear a distant voice say \"hello\".\n"; got != want {
+		t.Errorf("Got %q, wanted %q", got, want)
+	}
+	r.Broadcast("b", say("neighbour", nil))
+	if got := await(t, client.sent); !strings.HasSuffix(got, "neighbour says \"hello\".\n") {
+		t.Errorf("Got %q, wanted the neighbour heard up close", got)
+	}
+}
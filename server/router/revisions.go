@@ -0,0 +1,128 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
+)
+
+// crashLoop rolls every resource running the code of oc back to its last good revision, and marks
+// those without one as broken.
+func (r *Router) crashLoop(oc ownerCode, cause error) {
+	resources := []string{}
+	r.handlerLock.RLock()
+	for id, hd := range r.handlerDataByResource {
+		if hd.oc == oc {
+			resources = append(resources, id)
+		}
+	}
+	r.handlerLock.RUnlock()
+	for _, id := range resources {
+		rolledBack, err := r.rollbackResource(id)
+		if err != nil {
+			r.debugHandler("*** FAILED ROLLING BACK %q: %v ***", id, err)
+		}
+		if !rolledBack {
+			if err := r.markBroken(id, cause); err != nil {
+				r.debugHandler("*** FAILED MARKING %q BROKEN: %v ***", id, err)
+			}
+		}
+	}
+}
+
+// markBroken stops running resourceId until its code is changed, and tells its owner and container.
+func (r *Router) markBroken(resourceId string, cause error) error {
+	res := &resource.Resource{}
+	if err := r.persister.Transact(func(p *persist.Persister) error {
+		if err := p.Get(resourceId, res); err != nil {
+			return err
+		}
+		res.Broken = true
+		res.BrokenReason = cause.Error()
+		return p.Put(resourceId, res)
+	}); err != nil {
+		return err
+	}
+	if err := r.forget(resourceId); err != nil {
+		return err
+	}
+
+	r.clientLock.RLock()
+	owner, found := r.clients[res.Owner]
+	r.clientLock.RUnlock()
+	if found {
+		if merr := owner.SendToClient(fmt.Sprintf("%q is broken and won't run until its code is changed: %v\n", resourceId, cause)); merr != nil {
+			r.debugHandler("*** FAILED TELLING %q ABOUT %q BREAKING: %v ***", res.Owner, resourceId, merr.ToErr())
+		}
+	}
+	if res.Container != "" {
+		go r.Broadcast(res.Container, &messages.Event{
+			Type:   messages.EventTypeBroken,
+			Source: resourceId,
+			Metadata: map[string]string{
+				messages.MetadataPayload: res.BrokenReason,
+			},
+		})
+	}
+	return nil
+}
+
+// rollbackResource marks the current code of resourceId as broken, and restores the last good revision
+// if there is one.
+func (r *Router) rollbackResource(resourceId string) (bool, error) {
+	var restored *revision.Revision
+	if err := r.persister.Transact(func(p *persist.Persister) error {
+		res := &resource.Resource{}
+		if err := p.Get(resourceId, res); err != nil {
+			return err
+		}
+		if err := revision.MarkBroken(p, resourceId, revision.Hash(res.Code)); err != nil {
+			return err
+		}
+		good, err := revision.LastGood(p, resourceId)
+		if err == persist.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if restored, err = revision.Save(p, resourceId, revision.AuthorRollback, good.Code, good.Binary); err != nil {
+			return err
+		}
+		res.Code = good.Code
+		return p.Put(resourceId, res)
+	}); err != nil {
+		return false, err
+	}
+	if restored == nil {
+		return false, nil
+	}
+	r.debugHandler("Rolled %q back to revision %v", resourceId, restored.Number)
+	if err := r.forget(resourceId); err != nil {
+		return true, err
+	}
+	_, err := r.MCP(resourceId)
+	return true, err
+}
+
+// forget drops the MCP of resourceId without asking it anything, since it's too broken to answer,
+// and stops the MCP if no other resource uses it. The logs are kept, since they tell the owner why.
+func (r *Router) forget(resourceId string) error {
+	r.handlerLock.Lock()
+	defer r.handlerLock.Unlock()
+	hd, found := r.handlerDataByResource[resourceId]
+	if !found {
+		return nil
+	}
+	delete(r.handlerDataByResource, resourceId)
+	r.UnregisterSubscriber(resourceId)
+	for _, other := range r.handlerDataByResource {
+		if other.m == hd.m {
+			return nil
+		}
+	}
+	delete(r.handlerByOwnerCode, hd.oc)
+	return hd.m.Stop()
+}
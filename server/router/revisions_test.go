@@ -0,0 +1,86 @@
+package router
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zond/hackyhack/proc/errors"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
+)
+
+// startCrashLoop starts resourceId and pretends its MCP crash-looped.
+func startCrashLoop(t *testing.T, r *Router, resourceId string) {
+	if _, err := r.MCP(resourceId); err != nil {
+		t.Fatal(err)
+	}
+	r.handlerLock.RLock()
+	oc := r.handlerDataByResource[resourceId].oc
+	r.handlerLock.RUnlock()
+	r.crashLoop(oc, fmt.Errorf("crashed"))
+}
+
+func TestCrashLoopMarksBroken(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "res", "owner", initialVoid, messages.VoidResource)
+	startCrashLoop(t, r, "res")
+
+	res := &resource.Resource{}
+	if err := r.persister.Get("res", res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Broken || res.BrokenReason != "crashed" {
+		t.Errorf("Got %+v, wanted broken because it crashed", res)
+	}
+	if _, err := r.MCP("res"); err != errors.ErrBroken {
+		t.Errorf("Got %v, wanted %v", err, errors.ErrBroken)
+	}
+}
+
+func TestCrashLoopRollsBack(t *testing.T) {
+	r := testRouter(t)
+	good := transformCode(rotateLeft)
+	putResource(t, r, "res", "owner", good, messages.VoidResource)
+	if _, err := revision.Save(r.persister, "res", "owner", good, ""); err != nil {
+		t.Fatal(err)
+	}
+	bad := transformCode(rotateRight)
+	res := &resource.Resource{}
+	if err := r.persister.Get("res", res); err != nil {
+		t.Fatal(err)
+	}
+	res.Code = bad
+	if err := r.persister.Put("res", res); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := revision.Save(r.persister, "res", "owner", bad, ""); err != nil {
+		t.Fatal(err)
+	}
+	startCrashLoop(t, r, "res")
+
+	if err := r.persister.Get("res", res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Broken || res.Code != good {
+		t.Errorf("Got broken %v running good code %v, wanted the good code back", res.Broken, res.Code == good)
+	}
+	revs, err := revision.List(r.persister, "res")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 3 {
+		t.Fatalf("Got %+v, wanted the good, the bad and the rollback revisions", revs)
+	}
+	for _, rev := range revs {
+		if wantBroken := rev.Code == bad; rev.Broken != wantBroken {
+			t.Errorf("Got revision %v broken %v, wanted %v", rev.Number, rev.Broken, wantBroken)
+		}
+		if rev.Number == 3 && rev.Author != revision.AuthorRollback {
+			t.Errorf("Got revision 3 by %q, wanted %q", rev.Author, revision.AuthorRollback)
+		}
+	}
+	if _, err := r.MCP("res"); err != nil {
+		t.Errorf("Got %v, wanted the rolled back resource running", err)
+	}
+}
@@ -7,20 +7,24 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/zond/hackyhack/logging"
 	"github.com/zond/hackyhack/proc"
+	"github.com/zond/hackyhack/proc/build"
 	"github.com/zond/hackyhack/proc/errors"
 	"github.com/zond/hackyhack/proc/mcp"
 	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/account"
+	"github.com/zond/hackyhack/server/logs"
 	"github.com/zond/hackyhack/server/persist"
 	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
 	"github.com/zond/hackyhack/server/router/validator"
 )
 
@@ -40,41 +44,20 @@ type resourceWrapper struct {
 	resource string
 }
 
-type subWrapper struct {
-	sub                  *messages.Subscription
-	compiledVerbReg      *regexp.Regexp
-	compiledMethReg      *regexp.Regexp
-	compiledEventTypeReg *regexp.Regexp
-}
-
-func (w *resourceWrapper) Subscribe(sub *messages.Subscription) *messages.Error {
-	compiledVerbReg, err := regexp.Compile(sub.VerbReg)
-	if err != nil {
-		return &messages.Error{
-			Message: err.Error(),
-			Code:    messages.ErrorCodeRegexp,
-		}
-	}
-	compiledMethReg, err := regexp.Compile(sub.MethReg)
-	if err != nil {
-		return &messages.Error{
-			Message: err.Error(),
-			Code:    messages.ErrorCodeRegexp,
+func (w *resourceWrapper) SetPermissions(perms map[string]messages.Permission) *messages.Error {
+	res := &resource.Resource{}
+	if err := w.router.persister.Transact(func(p *persist.Persister) error {
+		if err := p.Get(w.resource, res); err != nil {
+			return err
 		}
-	}
-	compiledEventTypeReg, err := regexp.Compile(sub.EventTypeReg)
-	if err != nil {
+		res.Permissions = perms
+		return p.Put(w.resource, res)
+	}); err != nil {
 		return &messages.Error{
-			Message: err.Error(),
-			Code:    messages.ErrorCodeRegexp,
+			Message: fmt.Sprintf("Storing permissions failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
 		}
 	}
-	w.router.RegisterSubscriber(w.resource, &subWrapper{
-		sub:                  sub,
-		compiledVerbReg:      compiledVerbReg,
-		compiledMethReg:      compiledMethReg,
-		compiledEventTypeReg: compiledEventTypeReg,
-	})
 	return nil
 }
 
@@ -100,6 +83,148 @@ func (w *resourceWrapper) GetContent() ([]string, *messages.Error) {
 	return res.Content, nil
 }
 
+func (w *resourceWrapper) Create(c *messages.Create) (string, *messages.Error) {
+	creator := &resource.Resource{}
+	if err := w.router.persister.Get(w.resource, creator); err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+
+	code := c.Code
+	if c.CodeFrom != "" {
+		source := &resource.Resource{}
+		if err := w.router.persister.Get(c.CodeFrom, source); err == persist.ErrNotFound {
+			return "", &messages.Error{
+				Message: fmt.Sprintf("No resource %q found.", c.CodeFrom),
+				Code:    messages.ErrorCodeNoSuchResource,
+			}
+		} else if err != nil {
+			return "", &messages.Error{
+				Message: fmt.Sprintf("persister.Get failed: %v", err),
+				Code:    messages.ErrorCodeDatabase,
+			}
+		}
+		if source.Owner != creator.Owner {
+			return "", &messages.Error{
+				Message: fmt.Sprintf("Resource %q isn't yours.", c.CodeFrom),
+				Code:    messages.ErrorCodeNotOwner,
+			}
+		}
+		code = source.Code
+	}
+
+	acc, err := account.Get(w.router.persister, creator.Owner)
+	if err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("account.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	if acc.Suspended {
+		return "", &messages.Error{
+			Message: "Your objects are suspended until your CPU budget is refilled.",
+			Code:    messages.ErrorCodeSuspended,
+		}
+	}
+	if acc.OverStorage() {
+		return "", &messages.Error{
+			Message: account.ErrStorageQuota.Error(),
+			Code:    messages.ErrorCodeQuotaExceeded,
+		}
+	}
+
+	if err := validator.Validate(code); err != nil {
+		return "", &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeValidation,
+		}
+	}
+	binary, err := w.router.buildCache.Binary(code)
+	if err != nil {
+		return "", &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeBuild,
+		}
+	}
+
+	container := creator.Id
+	if c.InContainer {
+		container = creator.Container
+	}
+	if container == "" {
+		return "", &messages.Error{
+			Message: "Creator has no container.",
+			Code:    messages.ErrorCodeNoSuchResource,
+		}
+	}
+
+	now := time.Now()
+	res := &resource.Resource{
+		Id:        fmt.Sprintf("%x%x", rand.Int63(), rand.Int63()),
+		Owner:     creator.Owner,
+		Code:      code,
+		UpdatedAt: now,
+		CreatedAt: now,
+	}
+	if err := w.router.persister.Transact(func(p *persist.Persister) error {
+		if err := p.Put(res.Id, res); err != nil {
+			return err
+		}
+		if _, err := revision.Save(p, res.Id, w.resource, code, filepath.Base(binary)); err != nil {
+			return err
+		}
+		return res.MoveTo(p, container)
+	}); err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("Storing resource failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+
+	if _, err := w.router.MCP(res.Id); err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("MCP failed: %v", err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+
+	return res.Id, nil
+}
+
+func (w *resourceWrapper) GetQuota() (*messages.Quota, *messages.Error) {
+	return w.router.Quota(w.resource)
+}
+
+// Quota returns the quota of the owner of resourceId.
+func (r *Router) Quota(resourceId string) (*messages.Quota, *messages.Error) {
+	res := &resource.Resource{}
+	if err := r.persister.Get(resourceId, res); err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	acc, err := account.Get(r.persister, res.Owner)
+	if err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("account.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return &messages.Quota{
+		CPU:          acc.WindowCPU,
+		CPUQuota:     account.CPUQuota,
+		RSS:          acc.RSS,
+		RSSQuota:     account.RSSQuota,
+		Storage:      acc.Storage,
+		StorageQuota: account.StorageQuota,
+		WindowEnd:    acc.WindowEnd(),
+		Suspended:    acc.Suspended,
+	}, nil
+}
+
 func (w *resourceWrapper) EmitEvent(ev *messages.Event) *messages.Error {
 	if ev.Type == messages.EventTypeRequest {
 		return &messages.Error{
@@ -110,6 +235,9 @@ func (w *resourceWrapper) EmitEvent(ev *messages.Event) *messages.Error {
 	ev.Request = nil
 	ev.Source = w.resource
 	ev.SourceShortDesc = nil
+	ev.ObjectShortDesc = nil
+	ev.TargetShortDesc = nil
+	ev.Attenuation = 0
 	res := &resource.Resource{}
 	if err := w.router.persister.Get(w.resource, res); err != nil {
 		return &messages.Error{
@@ -123,6 +251,8 @@ func (w *resourceWrapper) EmitEvent(ev *messages.Event) *messages.Error {
 
 type Client interface {
 	Send(string) error
+	Terminal() *messages.Terminal
+	SendGMCP(pkg string, payload []byte) error
 }
 
 type clientWrapper struct {
@@ -141,6 +271,18 @@ func (w *clientWrapper) SendToClient(s string) *messages.Error {
 	return nil
 }
 
+func (w *clientWrapper) GetTerminal() (*messages.Terminal, *messages.Error) {
+	return w.client.Terminal(), nil
+}
+
+// GetTerminal is only found for resources without client, since clientWrapper shadows it.
+func (w *resourceWrapper) GetTerminal() (*messages.Terminal, *messages.Error) {
+	return nil, &messages.Error{
+		Message: "No client connected.",
+		Code:    messages.ErrorCodeNoClient,
+	}
+}
+
 type ownerCode struct {
 	owner    string
 	codeHash string
@@ -164,13 +306,18 @@ type handlerData struct {
 
 type Router struct {
 	persister             *persist.Persister
+	buildCache            *build.Cache
+	sandbox               *mcp.Sandbox
+	webURL                string
 	handlerByOwnerCode    map[ownerCode]*mcp.MCP
 	handlerDataByResource map[string]handlerData
 	handlerLock           sync.RWMutex
 	clientLock            sync.RWMutex
 	clients               map[string]*clientWrapper
 	subscriberLock        sync.RWMutex
-	subscribers           map[string]*subWrapper
+	subscribers           map[string]map[string]*subWrapper
+	chargedCPU            map[*mcp.MCP]time.Duration
+	logs                  *logs.Buffers
 	debugHandler          logging.Outputter
 }
 
@@ -190,13 +337,17 @@ func (r *Router) ensureVoid() error {
 	return nil
 }
 
-func New(p *persist.Persister) (*Router, error) {
+func New(p *persist.Persister, c *build.Cache, sandbox *mcp.Sandbox) (*Router, error) {
 	r := &Router{
 		persister:             p,
+		buildCache:            c,
+		sandbox:               sandbox,
 		handlerByOwnerCode:    map[ownerCode]*mcp.MCP{},
 		handlerDataByResource: map[string]handlerData{},
 		clients:               map[string]*clientWrapper{},
-		subscribers:           map[string]*subWrapper{},
+		subscribers:           map[string]map[string]*subWrapper{},
+		chargedCPU:            map[*mcp.MCP]time.Duration{},
+		logs:                  logs.NewBuffers(logs.DefaultSize),
 		debugHandler: func(f string, i ...interface{}) {
 			log.Print(spew.Sprintf(f, i...))
 		},
@@ -211,19 +362,11 @@ func New(p *persist.Persister) (*Router, error) {
 		return nil, err
 	}
 
-	return r, nil
-}
-
-func (r *Router) RegisterSubscriber(resource string, sub *subWrapper) {
-	r.subscriberLock.Lock()
-	defer r.subscriberLock.Unlock()
-	r.subscribers[resource] = sub
-}
+	go r.account()
+	go r.tick()
+	go r.heartbeat()
 
-func (r *Router) UnregisterSubscriber(resource string) {
-	r.subscriberLock.Lock()
-	defer r.subscriberLock.Unlock()
-	delete(r.subscribers, resource)
+	return r, nil
 }
 
 func (r *Router) RegisterClient(resource string, client Client) {
@@ -244,23 +387,24 @@ func (r *Router) UnregisterClient(resource string) {
 	delete(r.clients, resource)
 }
 
+// Decomission destructs resourceId and forgets its logs, and stops its MCP if no other resource uses it.
 func (r *Router) Decomission(resourceId string) (bool, error) {
+	// Broken resources have no MCP, but keep the logs of their last run until decomissioned.
+	defer r.logs.Drop(resourceId)
+	return r.decomission(resourceId)
+}
+
+// decomission destructs resourceId, and stops its MCP if no other resource uses it. The logs are
+// kept, since a restarted resource is still the same resource.
+func (r *Router) decomission(resourceId string) (bool, error) {
 	r.handlerLock.RLock()
 	hd, found := r.handlerDataByResource[resourceId]
 	r.handlerLock.RUnlock()
 	if found {
-		m, err := r.MCP(resourceId)
+		sd, err := r.shortDesc(resourceId)
 		if err != nil {
 			return false, err
 		}
-		var sd *messages.ShortDesc
-		var merr *messages.Error
-		if err := m.Call(resourceId, resourceId, messages.MethodGetShortDesc, nil, &[]interface{}{&sd, &merr}); err != nil {
-			return false, err
-		}
-		if merr != nil {
-			return false, merr.ToErr()
-		}
 		r.handlerLock.Lock()
 		if err := func() error {
 			defer r.handlerLock.Unlock()
@@ -279,6 +423,7 @@ func (r *Router) Decomission(resourceId string) (bool, error) {
 					SourceShortDesc: sd,
 				})
 				delete(r.handlerDataByResource, resourceId)
+				r.UnregisterSubscriber(resourceId)
 				if hd.m.Count() == 0 {
 					if err := hd.m.Stop(); err != nil {
 						return err
@@ -294,20 +439,49 @@ func (r *Router) Decomission(resourceId string) (bool, error) {
 	return found, nil
 }
 
-func (r *Router) Restart(resource string) error {
-	found, err := r.Decomission(resource)
+// Restart stops resourceId if it's running, and starts it with its current owner and code.
+func (r *Router) Restart(resourceId string) error {
+	if _, err := r.decomission(resourceId); err != nil {
+		return err
+	}
+	res := &resource.Resource{}
+	if err := r.persister.Get(resourceId, res); err != nil {
+		return err
+	}
+	// Broken resources wait for new code, but fixed ones start even if breaking stopped them.
+	if res.Broken {
+		return nil
+	}
+	if err := r.unbreak(res); err != nil {
+		return err
+	}
+	_, err := r.MCP(resourceId)
+	return err
+}
+
+// unbreak gives the MCP running the code of res another chance if it crash-looped.
+func (r *Router) unbreak(res *resource.Resource) error {
+	oc, err := newOwnerCode(res.Owner, res.Code)
 	if err != nil {
 		return err
 	}
-	if found {
-		_, err := r.MCP(resource)
-		if err != nil {
-			return err
-		}
+	r.handlerLock.RLock()
+	m, found := r.handlerByOwnerCode[oc]
+	r.handlerLock.RUnlock()
+	if found && m.Broken() {
+		return m.Unbreak()
 	}
 	return nil
 }
 
+// Running returns whether resourceId has a running MCP.
+func (r *Router) Running(resourceId string) bool {
+	r.handlerLock.RLock()
+	defer r.handlerLock.RUnlock()
+	_, found := r.handlerDataByResource[resourceId]
+	return found
+}
+
 func (r *Router) findResource(source, id string) ([]interface{}, error) {
 	var result []interface{}
 
@@ -346,6 +520,17 @@ func (r *Router) findResource(source, id string) ([]interface{}, error) {
 	}
 	result = append(result, proc.ResourceProxy{
 		SendRequest: func(req *messages.Request) (*messages.Response, error) {
+			if !res.Allows(src, req.Method) {
+				return &messages.Response{
+					Header: messages.ResponseHeader{
+						Id: req.Header.Id,
+						Error: &messages.Error{
+							Message: fmt.Sprintf("%q isn't allowed to call %q on %q.", src.Id, req.Method, res.Id),
+							Code:    messages.ErrorCodePermissionDenied,
+						},
+					},
+				}, nil
+			}
 			resp, err := m.SendRequest(req)
 			if err == nil {
 				go r.broadcastRequest(req)
@@ -360,43 +545,22 @@ func (r *Router) findResource(source, id string) ([]interface{}, error) {
 func (r *Router) Broadcast(container string, event *messages.Event) {
 	defer r.debugHandler.Trace("Router#Broadcast(%q, %#v)", container, event)()
 
-	cont := &resource.Resource{}
-	if err := r.persister.Get(container, cont); err != nil {
+	audience, err := r.audience(container, event.Propagation)
+	if err != nil {
 		r.debugHandler("*** MISSING CONTAINER WHEN BROADCASTING %#v in %q: %v ***", event, container, err)
 		return
 	}
 
-	for _, res := range cont.Content {
-		go func(res string) {
-			r.subscriberLock.RLock()
-			wrapper, found := r.subscribers[res]
-			r.subscriberLock.RUnlock()
-			if found {
-				matches := false
-				if event.Type == messages.EventTypeRequest {
-					matches =
-						event.Request.Header.Verb.Matches(wrapper.compiledVerbReg) ||
-							wrapper.compiledMethReg.MatchString(event.Request.Method) ||
-							wrapper.compiledEventTypeReg.MatchString(string(event.Type))
-				} else {
-					matches = wrapper.compiledEventTypeReg.MatchString(string(event.Type))
-				}
-				if matches {
-					m, err := r.MCP(res)
-					if err != nil {
-						r.debugHandler("*** BROKEN MCP WHEN BROADCASTING: %q ***", res)
-						return
-					}
-					var cont bool
-					if err := m.Call(res, res, wrapper.sub.HandlerName, []interface{}{
-						event,
-					}, &[]interface{}{&cont}); err != nil || !cont {
-						r.debugHandler("Unsubscribing %q (%v, %v)", err, cont)
-						r.UnregisterSubscriber(res)
-					}
-				}
-			}
-		}(res)
+	for res, attenuation := range audience {
+		heard := event
+		if attenuation > 0 {
+			attenuated := *event
+			attenuated.Attenuation = attenuation
+			heard = &attenuated
+		}
+		for _, wrapper := range r.subscriptions(res) {
+			go r.deliver(res, wrapper, heard)
+		}
 	}
 }
 
@@ -427,6 +591,9 @@ func (r *Router) createMCP(resourceId string) (*mcp.MCP, error) {
 	if err := r.persister.Get(resourceId, res); err != nil {
 		return nil, err
 	}
+	if res.Broken {
+		return nil, errors.ErrBroken
+	}
 	if err := validator.Validate(res.Code); err != nil {
 		return nil, err
 	}
@@ -447,10 +614,13 @@ func (r *Router) createMCP(resourceId string) (*mcp.MCP, error) {
 		return m, nil
 	}
 
-	m, err = mcp.New(res.Code, r.findResource)
+	m, err = mcp.New(res.Code, r.buildCache, r.findResource)
 	if err != nil {
 		return nil, err
 	}
+	m.CrashLoopHandler(func(cause error) {
+		r.crashLoop(oc, cause)
+	}).StderrHandler(r.stderrHandler(oc)).Sandbox(r.sandbox)
 	if err := m.Start(); err != nil {
 		return nil, err
 	}
@@ -467,6 +637,7 @@ func (r *Router) createMCP(resourceId string) (*mcp.MCP, error) {
 }
 
 func (r *Router) construct(m *mcp.MCP, res *resource.Resource) error {
+	r.loadSubscriptions(res)
 	if _, err := m.Construct(res.Id); err != nil {
 		return err
 	}
@@ -0,0 +1,493 @@
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zond/hackyhack/proc"
+	"github.com/zond/hackyhack/proc/build"
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/account"
+	"github.com/zond/hackyhack/server/challenge"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
+	"github.com/zond/hackyhack/server/state"
+	"github.com/zond/hackyhack/server/timer"
+	"github.com/zond/hackyhack/server/user"
+)
+
+// resourceCode is code for a resource describing itself by its id, with the methods in handlerMethods
+// and the imports they need in handlerImports.
+const resourceCode = `package main
+
+import (
+	handlerImports
+	"github.com/zond/hackyhack/proc/interfaces"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/proc/slave"
+)
+
+type handler struct {
+	m interfaces.MCP
+}
+
+func New(m interfaces.MCP) interfaces.Describable {
+	return &handler{
+		m: m,
+	}
+}
+
+func (h *handler) GetShortDesc() (*messages.ShortDesc, *messages.Error) {
+	return &messages.ShortDesc{
+		Value: h.m.GetResource(),
+	}, nil
+}
+
+func (h *handler) GetLongDesc() (string, *messages.Error) {
+	return "", nil
+}
+
+handlerMethods
+
+func main() {
+	slave.Register(New)
+}
+`
+
+func codeWith(imports, methods string) string {
+	return strings.NewReplacer("handlerImports", imports, "handlerMethods", methods).Replace(resourceCode)
+}
+
+// testClient is a client collecting what is sent to it.
+type testClient struct {
+	sent     chan string
+	gmcp     chan string
+	terminal *messages.Terminal
+	gmcpErr  error
+}
+
+func newTestClient() *testClient {
+	return &testClient{
+		sent: make(chan string, 16),
+		gmcp: make(chan string, 16),
+	}
+}
+
+func (c *testClient) Send(s string) error {
+	c.sent <- s
+	return nil
+}
+
+func (c *testClient) Terminal() *messages.Terminal {
+	return c.terminal
+}
+
+func (c *testClient) SendGMCP(pkg string, payload []byte) error {
+	if c.gmcpErr != nil {
+		return c.gmcpErr
+	}
+	c.gmcp <- pkg + " " + string(payload)
+	return nil
+}
+
+// await returns the next string from c.
+func await(t *testing.T, c chan string) string {
+	select {
+	case s := <-c:
+		return s
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Nothing sent")
+	}
+	return ""
+}
+
+// testRouter returns a router backed by a Bolt database in a temporary directory, since only Bolt
+// rolls back failed transactions.
+func testRouter(t *testing.T) *Router {
+	dir, err := ioutil.TempDir("", "router_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	b, err := persist.NewBolt(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Close()
+	})
+	p := &persist.Persister{Backend: b}
+	for _, idx := range []struct {
+		tmpl   interface{}
+		fields []string
+	}{
+		{user.User{}, []string{"Username", "Resource"}},
+		{resource.Resource{}, []string{"Owner", "Container"}},
+		{challenge.Challenge{}, []string{"Attacker", "Target"}},
+		{timer.Timer{}, []string{"Resource", "At"}},
+		{state.Entry{}, []string{"Resource"}},
+		{revision.Revision{}, []string{"Resource"}},
+	} {
+		if err := p.Index(idx.tmpl, idx.fields...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := build.NewCache(filepath.Join(dir, "build"), build.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(p, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.debugHandler = func(string, ...interface{}) {}
+	t.Cleanup(func() {
+		r.handlerLock.Lock()
+		defer r.handlerLock.Unlock()
+		for _, m := range r.handlerByOwnerCode {
+			m.Stop()
+		}
+	})
+	return r
+}
+
+// putResource stores a resource with code owned by owner in container.
+func putResource(t *testing.T, r *Router, id, owner, code, container string) *resource.Resource {
+	now := time.Now()
+	res := &resource.Resource{
+		Id:        id,
+		Owner:     owner,
+		Code:      code,
+		UpdatedAt: now,
+		CreatedAt: now,
+	}
+	if err := r.persister.Put(id, res); err != nil {
+		t.Fatal(err)
+	}
+	if container != "" {
+		if err := res.MoveTo(r.persister, container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res
+}
+
+// containerOf returns the container of id, and checks that the container agrees about it.
+func containerOf(t *testing.T, r *Router, id string) string {
+	res := &resource.Resource{}
+	if err := r.persister.Get(id, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Container == "" {
+		return ""
+	}
+	container := &resource.Resource{}
+	if err := r.persister.Get(res.Container, container); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, content := range container.Content {
+		if content == id {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Got %q %v times in the content of %q, wanted once", id, count, res.Container)
+	}
+	return res.Container
+}
+
+func ownedBy(t *testing.T, r *Router, owner string) []resource.Resource {
+	result := []resource.Resource{}
+	if err := r.persister.Find(persist.NewF(resource.Resource{
+		Owner: owner,
+	}).Add("Owner"), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestCreate(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "creator", "owner", initialVoid, messages.VoidResource)
+	w := &resourceWrapper{
+		router:   r,
+		resource: "creator",
+	}
+
+	id, merr := w.Create(&messages.Create{
+		Code:        initialVoid,
+		InContainer: true,
+	})
+	if merr != nil {
+		t.Fatal(merr.ToErr())
+	}
+	created := &resource.Resource{}
+	if err := r.persister.Get(id, created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Owner != "owner" || created.Container != messages.VoidResource {
+		t.Errorf("Got %+v, wanted owned by owner in the void", created)
+	}
+	void := &resource.Resource{}
+	if err := r.persister.Get(messages.VoidResource, void); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, content := range void.Content {
+		found = found || content == id
+	}
+	if !found {
+		t.Errorf("Got void content %v, wanted %q in it", void.Content, id)
+	}
+	if revs, err := revision.List(r.persister, id); err != nil || len(revs) != 1 {
+		t.Errorf("Got revisions %+v, %v, wanted one", revs, err)
+	}
+}
+
+func TestCreateWithoutContainer(t *testing.T) {
+	r := testRouter(t)
+	creator := putResource(t, r, "creator", "owner", initialVoid, "")
+	// A container that has disappeared makes the move fail after the resource is stored.
+	creator.Container = "missing"
+	if err := r.persister.Put(creator.Id, creator); err != nil {
+		t.Fatal(err)
+	}
+	w := &resourceWrapper{
+		router:   r,
+		resource: "creator",
+	}
+	if _, merr := w.Create(&messages.Create{
+		Code:        initialVoid,
+		InContainer: true,
+	}); merr == nil {
+		t.Fatalf("Wanted create in a missing container to fail")
+	}
+	if owned := ownedBy(t, r, "owner"); len(owned) != 1 {
+		t.Errorf("Got %+v, wanted only the creator left", owned)
+	}
+}
+
+func TestCreateOverQuota(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		acc  account.Account
+		code messages.ErrorCode
+	}{
+		{"suspended", account.Account{Owner: "owner", Suspended: true, WindowStart: time.Now()}, messages.ErrorCodeSuspended},
+		{"storage", account.Account{Owner: "owner", Storage: account.StorageQuota + 1, WindowStart: time.Now()}, messages.ErrorCodeQuotaExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRouter(t)
+			putResource(t, r, "creator", "owner", initialVoid, messages.VoidResource)
+			if err := r.persister.Put("owner", &tc.acc); err != nil {
+				t.Fatal(err)
+			}
+			w := &resourceWrapper{
+				router:   r,
+				resource: "creator",
+			}
+			if _, merr := w.Create(&messages.Create{
+				Code: initialVoid,
+			}); merr == nil || merr.Code != tc.code {
+				t.Errorf("Got %v, wanted error code %v", merr, tc.code)
+			}
+			if owned := ownedBy(t, r, "owner"); len(owned) != 1 {
+				t.Errorf("Got %+v, wanted only the creator", owned)
+			}
+		})
+	}
+}
+
+func TestSetStateOverQuota(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "res", "owner", initialVoid, "")
+	if _, err := account.ChargeStorage(r.persister, "owner", account.StorageQuota); err != nil {
+		t.Fatal(err)
+	}
+	w := &resourceWrapper{
+		router:   r,
+		resource: "res",
+	}
+	if merr := w.SetState("key", "value"); merr == nil || merr.Code != messages.ErrorCodeQuotaExceeded {
+		t.Errorf("Got %v, wanted error code %v", merr, messages.ErrorCodeQuotaExceeded)
+	}
+	if _, merr := w.GetState("key"); merr == nil || merr.Code != messages.ErrorCodeNoSuchKey {
+		t.Errorf("Got %v, wanted nothing stored", merr)
+	}
+	if merr := w.DeleteState("key"); merr == nil || merr.Code != messages.ErrorCodeNoSuchKey {
+		t.Errorf("Got %v, wanted error code %v", merr, messages.ErrorCodeNoSuchKey)
+	}
+}
+
+func TestDecomissionDropsLogs(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "res", "owner", initialVoid, messages.VoidResource)
+	if _, err := r.MCP("res"); err != nil {
+		t.Fatal(err)
+	}
+	w := &resourceWrapper{
+		router:   r,
+		resource: "res",
+	}
+	w.Log("hello")
+	if lines := r.logs.Lines("res"); len(lines) != 1 {
+		t.Fatalf("Got %+v, wanted the logged line", lines)
+	}
+	if found, err := r.Decomission("res"); err != nil || !found {
+		t.Fatalf("Got %v, %v, wanted res decomissioned", found, err)
+	}
+	if lines := r.logs.Lines("res"); len(lines) != 0 {
+		t.Errorf("Got %+v, wanted the logs dropped", lines)
+	}
+}
+
+// callAs calls method of id through findResource on behalf of source, like a child of source would.
+func callAs(t *testing.T, r *Router, source, id, method string) *messages.Error {
+	found, err := r.findResource(source, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, ok := found[len(found)-1].(proc.ResourceProxy)
+	if !ok {
+		t.Fatalf("Got %+v, wanted a proxy to %q last", found, id)
+	}
+	resp, err := proxy.SendRequest(&messages.Request{
+		Header: messages.RequestHeader{
+			Source: source,
+		},
+		Resource: id,
+		Method:   method,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Error
+}
+
+func TestFindResourcePermissions(t *testing.T) {
+	r := testRouter(t)
+	guarded := putResource(t, r, "guarded", "owner", codeWith("", ""), messages.VoidResource)
+	guarded.Permissions = map[string]messages.Permission{
+		messages.PermissionsDefault: messages.PermissionOwner,
+		messages.MethodGetShortDesc: messages.PermissionPublic,
+	}
+	if err := r.persister.Put(guarded.Id, guarded); err != nil {
+		t.Fatal(err)
+	}
+	putResource(t, r, "stranger", "other", codeWith("", ""), messages.VoidResource)
+	putResource(t, r, "sibling", "owner", codeWith("", ""), messages.VoidResource)
+
+	for _, tc := range []struct {
+		source   string
+		method   string
+		wantCode messages.ErrorCode
+	}{
+		{"stranger", messages.MethodGetLongDesc, messages.ErrorCodePermissionDenied},
+		{"stranger", messages.MethodGetShortDesc, noError},
+		{"sibling", messages.MethodGetLongDesc, noError},
+	} {
+		if merr := callAs(t, r, tc.source, "guarded", tc.method); errorCode(merr) != tc.wantCode {
+			t.Errorf("%v calling %v: got %v, wanted error code %v", tc.source, tc.method, merr, tc.wantCode)
+		}
+	}
+}
+
+// forger is code for a resource that claims to be victim, and makes victim public, as soon as it's
+// constructed. It writes the error of that request to stderr.
+const forger = `package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+func main() {
+	encoder := json.NewEncoder(os.Stdout)
+	decoder := json.NewDecoder(os.Stdin)
+	for {
+		blob := &messages.Blob{}
+		if err := decoder.Decode(blob); err != nil {
+			os.Exit(0)
+		}
+		switch blob.Type {
+		case messages.BlobTypeConstruct:
+			blob.Construct.Deconstructed = true
+			encoder.Encode(blob)
+			encoder.Encode(&messages.Blob{
+				Type: messages.BlobTypeRequest,
+				Request: &messages.Request{
+					Header: messages.RequestHeader{
+						Id:     "forged",
+						Source: "victim",
+					},
+					Resource:   "victim",
+					Method:     messages.MethodSetPerms,
+					Parameters: "[{}]",
+				},
+			})
+		case messages.BlobTypeResponse:
+			json.NewEncoder(os.Stderr).Encode(blob.Response.Header.Error)
+		}
+	}
+}
+`
+
+func TestForgedSource(t *testing.T) {
+	r := testRouter(t)
+	victim := putResource(t, r, "victim", "victimOwner", codeWith("", ""), messages.VoidResource)
+	victim.Permissions = map[string]messages.Permission{
+		messages.PermissionsDefault: messages.PermissionOwner,
+	}
+	if err := r.persister.Put(victim.Id, victim); err != nil {
+		t.Fatal(err)
+	}
+	putResource(t, r, "forger", "forgerOwner", forger, messages.VoidResource)
+
+	// The forger code doesn't pass validation, as if it had found a way around it.
+	m, err := mcp.New(forger, r.buildCache, r.findResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := make(chan []byte, 1)
+	m.StderrHandler(func(b []byte) {
+		stderr <- b
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if _, err := m.Construct("forger"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case b := <-stderr:
+		merr := &messages.Error{}
+		if err := json.Unmarshal(b, merr); err != nil {
+			t.Fatalf("Got %q, wanted an error: %v", b, err)
+		}
+		if merr.Code != messages.ErrorCodePermissionDenied {
+			t.Errorf("Got %+v, wanted error code %v", merr, messages.ErrorCodePermissionDenied)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Forged request never answered")
+	}
+	if err := r.persister.Get(victim.Id, victim); err != nil {
+		t.Fatal(err)
+	}
+	if len(victim.Permissions) == 0 {
+		t.Errorf("Wanted the permissions of victim untouched")
+	}
+}
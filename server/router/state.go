@@ -0,0 +1,73 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/account"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/state"
+)
+
+func (w *resourceWrapper) GetState(key string) (string, *messages.Error) {
+	entry, err := state.Get(w.router.persister, w.resource, key)
+	if err == persist.ErrNotFound {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("No state %q found.", key),
+			Code:    messages.ErrorCodeNoSuchKey,
+		}
+	} else if err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("state.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return entry.Value, nil
+}
+
+func (w *resourceWrapper) SetState(key, value string) *messages.Error {
+	res, merr := w.router.getResource(w.resource)
+	if merr != nil {
+		return merr
+	}
+	switch err := state.Set(w.router.persister, res.Owner, w.resource, key, value); err {
+	case nil:
+		return nil
+	case state.ErrKeySize, state.ErrValueSize, account.ErrStorageQuota:
+		return &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeQuotaExceeded,
+		}
+	default:
+		return &messages.Error{
+			Message: fmt.Sprintf("state.Set failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+}
+
+func (w *resourceWrapper) DeleteState(key string) *messages.Error {
+	if err := state.Del(w.router.persister, w.resource, key); err == persist.ErrNotFound {
+		return &messages.Error{
+			Message: fmt.Sprintf("No state %q found.", key),
+			Code:    messages.ErrorCodeNoSuchKey,
+		}
+	} else if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("state.Del failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return nil
+}
+
+func (w *resourceWrapper) ListState() ([]string, *messages.Error) {
+	keys, err := state.Keys(w.router.persister, w.resource)
+	if err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("state.Keys failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,216 @@
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+)
+
+type subWrapper struct {
+	sub                  messages.Subscription
+	compiledVerbReg      *regexp.Regexp
+	compiledMethReg      *regexp.Regexp
+	compiledEventTypeReg *regexp.Regexp
+}
+
+func newSubWrapper(sub messages.Subscription) (*subWrapper, error) {
+	compiledVerbReg, err := regexp.Compile(sub.VerbReg)
+	if err != nil {
+		return nil, err
+	}
+	compiledMethReg, err := regexp.Compile(sub.MethReg)
+	if err != nil {
+		return nil, err
+	}
+	compiledEventTypeReg, err := regexp.Compile(sub.EventTypeReg)
+	if err != nil {
+		return nil, err
+	}
+	return &subWrapper{
+		sub:                  sub,
+		compiledVerbReg:      compiledVerbReg,
+		compiledMethReg:      compiledMethReg,
+		compiledEventTypeReg: compiledEventTypeReg,
+	}, nil
+}
+
+func (w *subWrapper) matches(event *messages.Event) bool {
+	if event.Type == messages.EventTypeRequest {
+		return event.Request.Header.Verb.Matches(w.compiledVerbReg) ||
+			w.compiledMethReg.MatchString(event.Request.Method) ||
+			w.compiledEventTypeReg.MatchString(event.Type.String())
+	}
+	return w.compiledEventTypeReg.MatchString(event.Type.String())
+}
+
+func (w *resourceWrapper) Subscribe(sub *messages.Subscription) (string, *messages.Error) {
+	wrapper, err := newSubWrapper(*sub)
+	if err != nil {
+		return "", &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeRegexp,
+		}
+	}
+	if err := w.router.persister.Transact(func(p *persist.Persister) error {
+		res := &resource.Resource{}
+		if err := p.Get(w.resource, res); err != nil {
+			return err
+		}
+		// Resources tend to subscribe when constructed, so identical subscriptions are reused.
+		for id, existing := range res.Subscriptions {
+			existing.Id = wrapper.sub.Id
+			if existing == wrapper.sub {
+				wrapper.sub.Id = id
+				return nil
+			}
+		}
+		wrapper.sub.Id = fmt.Sprintf("%x%x", rand.Int63(), rand.Int63())
+		if res.Subscriptions == nil {
+			res.Subscriptions = map[string]messages.Subscription{}
+		}
+		res.Subscriptions[wrapper.sub.Id] = wrapper.sub
+		return p.Put(w.resource, res)
+	}); err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("Storing subscription failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	w.router.RegisterSubscription(w.resource, wrapper)
+	return wrapper.sub.Id, nil
+}
+
+func (w *resourceWrapper) Unsubscribe(id string) *messages.Error {
+	return w.router.unsubscribe(w.resource, id)
+}
+
+func (w *resourceWrapper) GetSubscriptions() ([]messages.Subscription, *messages.Error) {
+	res := &resource.Resource{}
+	if err := w.router.persister.Get(w.resource, res); err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	subs := make([]messages.Subscription, 0, len(res.Subscriptions))
+	for _, sub := range res.Subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Id < subs[j].Id
+	})
+	return subs, nil
+}
+
+// unsubscribe removes the subscription id of resourceId, both from memory and from the persister.
+func (r *Router) unsubscribe(resourceId, id string) *messages.Error {
+	found := false
+	if err := r.persister.Transact(func(p *persist.Persister) error {
+		res := &resource.Resource{}
+		if err := p.Get(resourceId, res); err != nil {
+			return err
+		}
+		if _, found = res.Subscriptions[id]; !found {
+			return nil
+		}
+		delete(res.Subscriptions, id)
+		return p.Put(resourceId, res)
+	}); err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("Removing subscription failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	r.UnregisterSubscription(resourceId, id)
+	if !found {
+		return &messages.Error{
+			Message: fmt.Sprintf("No subscription %q found.", id),
+			Code:    messages.ErrorCodeNoSuchSubscription,
+		}
+	}
+	return nil
+}
+
+// loadSubscriptions registers the persisted subscriptions of res.
+func (r *Router) loadSubscriptions(res *resource.Resource) {
+	for _, sub := range res.Subscriptions {
+		wrapper, err := newSubWrapper(sub)
+		if err != nil {
+			r.debugHandler("*** BROKEN SUBSCRIPTION %q OF %q: %v ***", sub.Id, res.Id, err)
+			continue
+		}
+		r.RegisterSubscription(res.Id, wrapper)
+	}
+}
+
+func (r *Router) RegisterSubscription(resource string, sub *subWrapper) {
+	r.subscriberLock.Lock()
+	defer r.subscriberLock.Unlock()
+	subs, found := r.subscribers[resource]
+	if !found {
+		subs = map[string]*subWrapper{}
+		r.subscribers[resource] = subs
+	}
+	subs[sub.sub.Id] = sub
+}
+
+func (r *Router) UnregisterSubscription(resource, id string) {
+	r.subscriberLock.Lock()
+	defer r.subscriberLock.Unlock()
+	delete(r.subscribers[resource], id)
+	if len(r.subscribers[resource]) == 0 {
+		delete(r.subscribers, resource)
+	}
+}
+
+// UnregisterSubscriber forgets all subscriptions of resource until it is constructed again.
+func (r *Router) UnregisterSubscriber(resource string) {
+	r.subscriberLock.Lock()
+	defer r.subscriberLock.Unlock()
+	delete(r.subscribers, resource)
+}
+
+func (r *Router) subscriptions(resource string) []*subWrapper {
+	r.subscriberLock.RLock()
+	defer r.subscriberLock.RUnlock()
+	result := make([]*subWrapper, 0, len(r.subscribers[resource]))
+	for _, wrapper := range r.subscribers[resource] {
+		result = append(result, wrapper)
+	}
+	return result
+}
+
+// deliver calls the handler of wrapper in res with event, if it matches, and cancels the subscription
+// if the handler asks for it or doesn't exist.
+func (r *Router) deliver(res string, wrapper *subWrapper, event *messages.Event) {
+	if !wrapper.matches(event) {
+		return
+	}
+	m, err := r.MCP(res)
+	if err != nil {
+		r.debugHandler("*** BROKEN MCP WHEN BROADCASTING: %q ***", res)
+		return
+	}
+	// Stopped children can't listen, and events aren't kept for later.
+	if m.Suspended() {
+		return
+	}
+	var cont bool
+	if err := m.Call(res, res, wrapper.sub.HandlerName, []interface{}{
+		event,
+	}, &[]interface{}{&cont}); err != nil && !mcp.IsNoSuchMethod(err) {
+		r.debugHandler("*** FAILED DELIVERING %v TO %q: %v ***", event.Type, res, err)
+		return
+	} else if err != nil || !cont {
+		r.debugHandler("Unsubscribing %q from %q (%v, %v)", res, wrapper.sub.Id, err, cont)
+		if merr := r.unsubscribe(res, wrapper.sub.Id); merr != nil {
+			r.debugHandler("*** FAILED UNSUBSCRIBING %q FROM %q: %v ***", res, wrapper.sub.Id, merr.ToErr())
+		}
+	}
+}
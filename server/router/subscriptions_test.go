@@ -0,0 +1,117 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+// listener is code for a resource logging the events its handlers get.
+var listener = codeWith(`"github.com/zond/hackyhack/client/util"`, `func (h *handler) Heard(ctx *messages.Context, ev *messages.Event) bool {
+	util.ResourceLogf(h.m, "heard %v %v", ev.Type, ev.Attenuation)
+	return true
+}
+
+func (h *handler) Moved(ctx *messages.Context, ev *messages.Event) bool {
+	util.ResourceLogf(h.m, "moved")
+	return true
+}`)
+
+// awaitLog returns the text of the next line in lines.
+func awaitLog(t *testing.T, lines <-chan messages.LogLine) string {
+	select {
+	case line := <-lines:
+		return line.Text
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Nothing logged")
+	}
+	return ""
+}
+
+func subscriptionIds(t *testing.T, w *resourceWrapper) []string {
+	subs, merr := w.GetSubscriptions()
+	if merr != nil {
+		t.Fatal(merr.ToErr())
+	}
+	ids := []string{}
+	for _, sub := range subs {
+		ids = append(ids, sub.Id)
+	}
+	return ids
+}
+
+func TestSubscriptions(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "listener", "owner", listener, messages.VoidResource)
+	if _, err := r.MCP("listener"); err != nil {
+		t.Fatal(err)
+	}
+	w := &resourceWrapper{
+		router:   r,
+		resource: "listener",
+	}
+	_, lines, unsubscribe := r.logs.Subscribe("listener")
+	defer unsubscribe()
+
+	heard, merr := w.Subscribe(&messages.Subscription{
+		EventTypeReg: "^Say$",
+		HandlerName:  "Heard",
+	})
+	if merr != nil {
+		t.Fatal(merr.ToErr())
+	}
+	moved, merr := w.Subscribe(&messages.Subscription{
+		EventTypeReg: "^Leave$",
+		HandlerName:  "Moved",
+	})
+	if merr != nil {
+		t.Fatal(merr.ToErr())
+	}
+	if heard == moved {
+		t.Fatalf("Got the same id %q for different subscriptions", heard)
+	}
+	if again, merr := w.Subscribe(&messages.Subscription{
+		EventTypeReg: "^Say$",
+		HandlerName:  "Heard",
+	}); merr != nil || again != heard {
+		t.Errorf("Got %q, %v, wanted the identical subscription %q reused", again, merr, heard)
+	}
+	if ids := subscriptionIds(t, w); len(ids) != 2 {
+		t.Errorf("Got subscriptions %v, wanted two", ids)
+	}
+
+	r.Broadcast(messages.VoidResource, &messages.Event{Type: messages.EventTypeSay})
+	if got := awaitLog(t, lines); got != "heard Say 0" {
+		t.Errorf("Got %q, wanted the say heard", got)
+	}
+	r.Broadcast(messages.VoidResource, &messages.Event{Type: messages.EventTypeLeave})
+	if got := awaitLog(t, lines); got != "moved" {
+		t.Errorf("Got %q, wanted the leave heard", got)
+	}
+
+	if merr := w.Unsubscribe(heard); merr != nil {
+		t.Fatal(merr.ToErr())
+	}
+	if merr := w.Unsubscribe(heard); merr == nil || merr.Code != messages.ErrorCodeNoSuchSubscription {
+		t.Errorf("Got %v, wanted error code %v", merr, messages.ErrorCodeNoSuchSubscription)
+	}
+	if ids := subscriptionIds(t, w); len(ids) != 1 || ids[0] != moved {
+		t.Errorf("Got subscriptions %v, wanted only %q", ids, moved)
+	}
+	if subs := r.subscriptions("listener"); len(subs) != 1 || subs[0].sub.Id != moved {
+		t.Errorf("Got registered %+v, wanted only %q", subs, moved)
+	}
+
+	// Restarting forgets the registered subscriptions, and constructing again loads the persisted ones.
+	if err := r.Restart("listener"); err != nil {
+		t.Fatal(err)
+	}
+	if subs := r.subscriptions("listener"); len(subs) != 1 || subs[0].sub.Id != moved {
+		t.Errorf("Got registered %+v after restarting, wanted only %q", subs, moved)
+	}
+	r.Broadcast(messages.VoidResource, &messages.Event{Type: messages.EventTypeLeave})
+	if got := awaitLog(t, lines); got != "moved" {
+		t.Errorf("Got %q, wanted the leave heard after restarting", got)
+	}
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/timer"
+)
+
+const (
+	timerInterval     = time.Second
+	heartbeatInterval = time.Minute
+	minTimerInterval  = time.Second
+	maxTimers         = 32
+)
+
+func (w *resourceWrapper) Schedule(t *messages.Timer) (string, *messages.Error) {
+	if t.Method == "" {
+		return "", &messages.Error{
+			Message: "Timers need a method to call.",
+			Code:    messages.ErrorCodeInvalidTimer,
+		}
+	}
+	if t.Interval != 0 && t.Interval < minTimerInterval {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("Timers can't repeat more often than every %v.", minTimerInterval),
+			Code:    messages.ErrorCodeInvalidTimer,
+		}
+	}
+	now := time.Now()
+	tim := &timer.Timer{
+		Id:        fmt.Sprintf("%x%x", rand.Int63(), rand.Int63()),
+		Resource:  w.resource,
+		Method:    t.Method,
+		At:        t.At,
+		Interval:  t.Interval,
+		CreatedAt: now,
+	}
+	if tim.At.IsZero() {
+		tim.At = now.Add(t.Interval)
+	}
+	var merr *messages.Error
+	if err := w.router.persister.Transact(func(p *persist.Persister) error {
+		timers, err := timer.ForResource(p, w.resource)
+		if err != nil {
+			return err
+		}
+		if len(timers) >= maxTimers {
+			merr = &messages.Error{
+				Message: fmt.Sprintf("Can't have more than %v timers.", maxTimers),
+				Code:    messages.ErrorCodeInvalidTimer,
+			}
+			return nil
+		}
+		return p.Put(tim.Id, tim)
+	}); err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("Storing timer failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	if merr != nil {
+		return "", merr
+	}
+	return tim.Id, nil
+}
+
+func (w *resourceWrapper) Unschedule(id string) *messages.Error {
+	tim := &timer.Timer{}
+	if err := w.router.persister.Transact(func(p *persist.Persister) error {
+		if err := p.Get(id, tim); err != nil {
+			return err
+		}
+		if tim.Resource != w.resource {
+			return persist.ErrNotFound
+		}
+		return p.Del(id, tim)
+	}); err == persist.ErrNotFound {
+		return &messages.Error{
+			Message: fmt.Sprintf("No timer %q found.", id),
+			Code:    messages.ErrorCodeNoSuchTimer,
+		}
+	} else if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("Removing timer failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return nil
+}
+
+func (w *resourceWrapper) GetTimers() ([]messages.Timer, *messages.Error) {
+	timers, err := timer.ForResource(w.router.persister, w.resource)
+	if err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("timer.ForResource failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	result := make([]messages.Timer, len(timers))
+	for index := range timers {
+		result[index] = *timers[index].ToMessage()
+	}
+	return result, nil
+}
+
+func (r *Router) tick() {
+	for now := range time.Tick(timerInterval) {
+		timers, err := timer.Due(r.persister, now)
+		if err != nil {
+			r.debugHandler("*** FAILED FINDING DUE TIMERS: %v ***", err)
+			continue
+		}
+		for index := range timers {
+			r.fire(&timers[index], now)
+		}
+	}
+}
+
+// fire reschedules or removes t, and calls its method, unless its resource is outside the world or
+// suspended, in which case t stays due until that changes.
+func (r *Router) fire(t *timer.Timer, now time.Time) {
+	res := &resource.Resource{}
+	if err := r.persister.Get(t.Resource, res); err == persist.ErrNotFound {
+		if err := r.persister.Del(t.Id, t); err != nil && err != persist.ErrNotFound {
+			r.debugHandler("*** FAILED REMOVING ORPHANED TIMER %q: %v ***", t.Id, err)
+		}
+		return
+	} else if err != nil {
+		r.debugHandler("*** MISSING RESOURCE FOR TIMER %q: %v ***", t.Id, err)
+		return
+	}
+	if res.Container == "" && res.Id != messages.VoidResource {
+		return
+	}
+	m, err := r.MCP(res.Id)
+	if err != nil {
+		r.debugHandler("*** BROKEN MCP WHEN FIRING TIMER %q: %v ***", t.Id, err)
+		return
+	}
+	if m.Suspended() {
+		return
+	}
+	msg := t.ToMessage()
+	if err := r.persister.Transact(func(p *persist.Persister) error {
+		if t.Interval == 0 {
+			return p.Del(t.Id, t)
+		}
+		if err := p.Get(t.Id, t); err != nil {
+			return err
+		}
+		// Intervals missed while the server was down or the resource away are skipped.
+		if t.At = t.At.Add(t.Interval); !t.At.After(now) {
+			t.At = now.Add(t.Interval)
+		}
+		return p.Put(t.Id, t)
+	}); err == persist.ErrNotFound {
+		// Unscheduled since we found it.
+		return
+	} else if err != nil {
+		r.debugHandler("*** FAILED RESCHEDULING TIMER %q: %v ***", t.Id, err)
+		return
+	}
+	go func() {
+		if err := m.Call(res.Id, res.Id, msg.Method, []interface{}{msg}, nil); err != nil {
+			r.debugHandler("*** TIMER %q OF %q FAILED: %v ***", msg.Id, res.Id, err)
+		}
+	}()
+}
+
+// heartbeat regularly tells all subscribers what time it is.
+func (r *Router) heartbeat() {
+	for now := range time.Tick(heartbeatInterval) {
+		event := &messages.Event{
+			Type: messages.EventTypeHeartbeat,
+			Metadata: map[string]string{
+				messages.MetadataTime: now.Format(time.RFC3339),
+			},
+		}
+		r.subscriberLock.RLock()
+		resources := make([]string, 0, len(r.subscribers))
+		for res := range r.subscribers {
+			resources = append(resources, res)
+		}
+		r.subscriberLock.RUnlock()
+		for _, res := range resources {
+			for _, wrapper := range r.subscriptions(res) {
+				go r.deliver(res, wrapper, event)
+			}
+		}
+	}
+}
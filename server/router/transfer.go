@@ -0,0 +1,189 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+)
+
+func (w *resourceWrapper) Take(item string) *messages.Error {
+	return w.router.transfer(w.resource, item, "", messages.EventTypeTake)
+}
+
+func (w *resourceWrapper) Drop(item string) *messages.Error {
+	return w.router.transfer(w.resource, item, "", messages.EventTypeDrop)
+}
+
+func (w *resourceWrapper) Give(item, recipient string) *messages.Error {
+	return w.router.transfer(w.resource, item, recipient, messages.EventTypeGive)
+}
+
+func (r *Router) getResource(id string) (*resource.Resource, *messages.Error) {
+	res := &resource.Resource{}
+	if err := r.persister.Get(id, res); err == persist.ErrNotFound {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("No resource %q found.", id),
+			Code:    messages.ErrorCodeNoSuchResource,
+		}
+	} else if err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("persister.Get failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return res, nil
+}
+
+func (r *Router) describe(id string) (*messages.ShortDesc, *messages.Error) {
+	sd, err := r.shortDesc(id)
+	if err != nil {
+		return nil, &messages.Error{
+			Message: fmt.Sprintf("Unable to describe %q: %v", id, err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+	return sd, nil
+}
+
+// allowMove asks the moved resource if it accepts the transfer. Resources not implementing
+// interfaces.Movable refuse.
+func (r *Router) allowMove(transfer *messages.Transfer) *messages.Error {
+	m, err := r.MCP(transfer.Resource)
+	if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("MCP failed: %v", err),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+	var allowed bool
+	var merr *messages.Error
+	if err := m.Call(transfer.Source, transfer.Resource, messages.MethodAllowMove, []interface{}{transfer}, &[]interface{}{&allowed, &merr}); err != nil && !mcp.IsNoSuchMethod(err) {
+		return &messages.Error{
+			Message: err.Error(),
+			Code:    messages.ErrorCodeProxyFailed,
+		}
+	}
+	if merr != nil {
+		return merr
+	}
+	if !allowed {
+		return &messages.Error{
+			Message: "It refuses to move.",
+			Code:    messages.ErrorCodeRefused,
+		}
+	}
+	return nil
+}
+
+// transfer moves item from the container of actor to actor (EventTypeTake), from actor to the
+// container of actor (EventTypeDrop) or from actor to recipient in the same container (EventTypeGive),
+// if item allows it, and tells the container of actor about it.
+func (r *Router) transfer(actor, item, recipient string, evType messages.EventType) *messages.Error {
+	actorRes, merr := r.getResource(actor)
+	if merr != nil {
+		return merr
+	}
+	itemRes, merr := r.getResource(item)
+	if merr != nil {
+		return merr
+	}
+	if item == actor {
+		return &messages.Error{
+			Message: "Can't move yourself that way.",
+			Code:    messages.ErrorCodeNotAdjacent,
+		}
+	}
+	if actorRes.Container == "" {
+		return &messages.Error{
+			Message: "Can't move things while nowhere.",
+			Code:    messages.ErrorCodeNotAdjacent,
+		}
+	}
+
+	transfer := &messages.Transfer{
+		Resource: item,
+		Source:   actor,
+		From:     itemRes.Container,
+	}
+	switch evType {
+	case messages.EventTypeTake:
+		if itemRes.Container != actorRes.Container {
+			return &messages.Error{
+				Message: fmt.Sprintf("%q isn't here.", item),
+				Code:    messages.ErrorCodeNotAdjacent,
+			}
+		}
+		transfer.To = actor
+	case messages.EventTypeDrop:
+		if itemRes.Container != actor {
+			return &messages.Error{
+				Message: fmt.Sprintf("%q isn't carried.", item),
+				Code:    messages.ErrorCodeNotAdjacent,
+			}
+		}
+		transfer.To = actorRes.Container
+	case messages.EventTypeGive:
+		if itemRes.Container != actor {
+			return &messages.Error{
+				Message: fmt.Sprintf("%q isn't carried.", item),
+				Code:    messages.ErrorCodeNotAdjacent,
+			}
+		}
+		recipientRes, merr := r.getResource(recipient)
+		if merr != nil {
+			return merr
+		}
+		if recipient == actor || recipient == item || recipientRes.Container != actorRes.Container {
+			return &messages.Error{
+				Message: fmt.Sprintf("%q isn't here.", recipient),
+				Code:    messages.ErrorCodeNotAdjacent,
+			}
+		}
+		transfer.To = recipient
+	default:
+		return &messages.Error{
+			Message: fmt.Sprintf("%v isn't a transfer.", evType),
+			Code:    messages.ErrorCodeEventType,
+		}
+	}
+	if merr := r.allowMove(transfer); merr != nil {
+		return merr
+	}
+
+	ev := &messages.Event{
+		Type:   evType,
+		Source: actor,
+		Object: item,
+	}
+	if ev.SourceShortDesc, merr = r.describe(actor); merr != nil {
+		return merr
+	}
+	if ev.ObjectShortDesc, merr = r.describe(item); merr != nil {
+		return merr
+	}
+	if evType == messages.EventTypeGive {
+		ev.Target = recipient
+		if ev.TargetShortDesc, merr = r.describe(recipient); merr != nil {
+			return merr
+		}
+	}
+
+	if err := r.relocate(item, transfer.From, transfer.To); err == errMovedMeanwhile {
+		return &messages.Error{
+			Message: fmt.Sprintf("%q moved elsewhere meanwhile.", item),
+			Code:    messages.ErrorCodeRefused,
+		}
+	} else if err != nil {
+		return &messages.Error{
+			Message: fmt.Sprintf("Moving failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+
+	go r.Broadcast(actorRes.Container, ev)
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/zond/hackyhack/proc/messages"
+)
+
+func TestTransfer(t *testing.T) {
+	r := testRouter(t)
+	putResource(t, r, "a", "owner", world, messages.VoidResource)
+	putResource(t, r, "actor", "owner", world, "a")
+	putResource(t, r, "other", "owner", world, "a")
+	putResource(t, r, "item", "owner", world, "a")
+	putResource(t, r, "heavy", "owner", world, "a")
+	putResource(t, r, "carriedHeavy", "owner", world, "actor")
+	// The void code doesn't implement AllowMove at all.
+	putResource(t, r, "rock", "owner", initialVoid, "a")
+	w := &resourceWrapper{
+		router:   r,
+		resource: "actor",
+	}
+
+	for _, step := range []struct {
+		name     string
+		transfer func() *messages.Error
+		item     string
+		wantCode messages.ErrorCode
+		wantIn   string
+	}{
+		{"take", func() *messages.Error { return w.Take("item") }, "item", noError, "actor"},
+		{"take refused", func() *messages.Error { return w.Take("heavy") }, "heavy", messages.ErrorCodeRefused, "a"},
+		{"take without AllowMove", func() *messages.Error { return w.Take("rock") }, "rock", messages.ErrorCodeRefused, "a"},
+		{"take carried", func() *messages.Error { return w.Take("item") }, "item", messages.ErrorCodeNotAdjacent, "actor"},
+		{"drop refused", func() *messages.Error { return w.Drop("carriedHeavy") }, "carriedHeavy", messages.ErrorCodeRefused, "actor"},
+		{"give refused", func() *messages.Error { return w.Give("carriedHeavy", "other") }, "carriedHeavy", messages.ErrorCodeRefused, "actor"},
+		{"give to missing", func() *messages.Error { return w.Give("item", "missing") }, "item", messages.ErrorCodeNoSuchResource, "actor"},
+		{"give", func() *messages.Error { return w.Give("item", "other") }, "item", noError, "other"},
+		{"drop not carried", func() *messages.Error { return w.Drop("item") }, "item", messages.ErrorCodeNotAdjacent, "other"},
+		{"take from other", func() *messages.Error { return w.Take("item") }, "item", messages.ErrorCodeNotAdjacent, "other"},
+		{"drop", func() *messages.Error { return (&resourceWrapper{router: r, resource: "other"}).Drop("item") }, "item", noError, "a"},
+	} {
+		if merr := step.transfer(); errorCode(merr) != step.wantCode {
+			t.Errorf("%v: got %v, wanted error code %v", step.name, merr, step.wantCode)
+		}
+		if got := containerOf(t, r, step.item); got != step.wantIn {
+			t.Errorf("%v: got %v in %q, wanted %q", step.name, step.item, got, step.wantIn)
+		}
+	}
+}
@@ -4,58 +4,275 @@ import (
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
-	slave = "\"github.com/zond/hackyhack/proc/slave\""
+	slave = "github.com/zond/hackyhack/proc/slave"
 )
 
-var allowedImports = map[string]bool{
-	slave:         true,
-	"\"strings\"": true,
-	"\"regexp\"":  true,
-	"\"strconv\"": true,
-	"\"bytes\"":   true,
-	"\"github.com/zond/hackyhack/client/events\"":        true,
-	"\"github.com/zond/hackyhack/client/commands\"":      true,
-	"\"github.com/zond/hackyhack/client/util\"":          true,
-	"\"github.com/zond/hackyhack/proc/interfaces\"":      true,
-	"\"github.com/zond/hackyhack/proc/messages\"":        true,
-	"\"github.com/zond/hackyhack/proc/slave/delegator\"": true,
+const (
+	RuleSyntax       = "syntax"
+	RuleFileSize     = "file-size"
+	RuleImport       = "import"
+	RuleUnsafe       = "unsafe"
+	RuleCgo          = "cgo"
+	RulePragma       = "pragma"
+	RuleGoStatements = "go-statements"
+	RuleFuncs        = "funcs"
+	RuleRegister     = "register"
+)
+
+// Policy is the rules code has to follow to be run.
+type Policy struct {
+	// AllowedImports are the import paths code may import.
+	AllowedImports map[string]bool
+	// ForbiddenSelectors are, per import path, the names code may not use even if the package is allowed.
+	ForbiddenSelectors map[string]map[string]bool
+	// ForbiddenMethods are field and method names code may not select on anything.
+	ForbiddenMethods map[string]bool
+	// MaxGoStatements is how many go statements code may contain.
+	MaxGoStatements int
+	// MaxFileSize is how many bytes code may be.
+	MaxFileSize int
+	// MaxFuncs is how many functions, methods and function literals code may contain.
+	MaxFuncs int
+	// RequireRegister requires func main to call slave.Register.
+	RequireRegister bool
+}
+
+// DefaultPolicy is the policy used by Validate.
+var DefaultPolicy = &Policy{
+	AllowedImports: map[string]bool{
+		slave:     true,
+		"strings": true,
+		"regexp":  true,
+		"strconv": true,
+		"bytes":   true,
+		"github.com/zond/hackyhack/client/events":        true,
+		"github.com/zond/hackyhack/client/commands":      true,
+		"github.com/zond/hackyhack/client/util":          true,
+		"github.com/zond/hackyhack/proc/interfaces":      true,
+		"github.com/zond/hackyhack/proc/messages":        true,
+		"github.com/zond/hackyhack/proc/slave/delegator": true,
+	},
+	// In case reflect ever gets allowed, these would let code read and write arbitrary memory.
+	ForbiddenSelectors: map[string]map[string]bool{
+		"reflect": {
+			"NewAt":        true,
+			"SliceHeader":  true,
+			"StringHeader": true,
+		},
+	},
+	ForbiddenMethods: map[string]bool{
+		"UnsafeAddr":    true,
+		"UnsafePointer": true,
+	},
+	MaxGoStatements: 8,
+	MaxFileSize:     1 << 16,
+	MaxFuncs:        256,
+	RequireRegister: true,
+}
+
+// Violation is a place where code breaks a rule of a Policy.
+type Violation struct {
+	Rule    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v:%v: %v", v.Line, v.Column, v.Message)
+}
+
+// Error is returned by Validate, and contains all violations found, ordered by position.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Violations))
+	for index, violation := range e.Violations {
+		lines[index] = violation.String()
+	}
+	return strings.Join(lines, "\n")
 }
 
 type validator struct {
-	disallowed   []string
-	importsSlave bool
+	policy     *Policy
+	fset       *token.FileSet
+	violations []Violation
+	// imports are the import paths by the name they are known as in the code.
+	imports       map[string]string
+	goStatements  int
+	funcs         int
+	callsRegister bool
 }
 
-func (v *validator) Visit(n ast.Node) ast.Visitor {
-	if importNode, isImport := n.(*ast.ImportSpec); isImport {
-		if importNode.Path != nil {
-			if !allowedImports[importNode.Path.Value] {
-				v.disallowed = append(v.disallowed, importNode.Path.Value)
+func (v *validator) violate(pos token.Pos, rule string, f string, args ...interface{}) {
+	position := v.fset.Position(pos)
+	if !position.IsValid() {
+		// Violations of the code as a whole are reported at its start.
+		position.Line, position.Column = 1, 1
+	}
+	v.violations = append(v.violations, Violation{
+		Rule:    rule,
+		Line:    position.Line,
+		Column:  position.Column,
+		Message: fmt.Sprintf(f, args...),
+	})
+}
+
+func (v *validator) checkImport(spec *ast.ImportSpec) {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		v.violate(spec.Path.Pos(), RuleSyntax, "Unparseable import path %v", spec.Path.Value)
+		return
+	}
+	switch {
+	case path == "C":
+		v.violate(spec.Pos(), RuleCgo, "Code may not use cgo")
+		return
+	case path == "unsafe":
+		v.violate(spec.Pos(), RuleUnsafe, "Code may not import %q", path)
+		return
+	case !v.policy.AllowedImports[path]:
+		v.violate(spec.Pos(), RuleImport, "Code imports disallowed package %q", path)
+		return
+	}
+	name := path[strings.LastIndex(path, "/")+1:]
+	if spec.Name != nil {
+		name = spec.Name.Name
+	}
+	if name == "." {
+		// Dot imports would make the selector checks blind.
+		v.violate(spec.Pos(), RuleImport, "Code may not dot import %q", path)
+		return
+	}
+	v.imports[name] = path
+}
+
+func (v *validator) checkComments(f *ast.File) {
+	for _, group := range f.Comments {
+		for _, comment := range group.List {
+			switch {
+			case strings.HasPrefix(comment.Text, "//go:"):
+				v.violate(comment.Pos(), RulePragma, "Code may not contain %v directives", strings.Fields(comment.Text)[0])
+			case strings.HasPrefix(strings.TrimLeft(comment.Text, "/* \t"), "#cgo"):
+				v.violate(comment.Pos(), RuleCgo, "Code may not contain cgo directives")
 			}
-			if importNode.Path.Value == slave {
-				v.importsSlave = true
+		}
+	}
+}
+
+func (v *validator) Visit(n ast.Node) ast.Visitor {
+	switch node := n.(type) {
+	case *ast.GoStmt:
+		v.goStatements++
+		if v.goStatements > v.policy.MaxGoStatements {
+			v.violate(node.Pos(), RuleGoStatements, "Code may contain at most %v go statements", v.policy.MaxGoStatements)
+		}
+	case *ast.FuncDecl, *ast.FuncLit:
+		v.funcs++
+		if v.funcs > v.policy.MaxFuncs {
+			v.violate(node.Pos(), RuleFuncs, "Code may contain at most %v functions", v.policy.MaxFuncs)
+		}
+	case *ast.SelectorExpr:
+		if v.policy.ForbiddenMethods[node.Sel.Name] {
+			v.violate(node.Sel.Pos(), RuleUnsafe, "Code may not use %v", node.Sel.Name)
+		}
+		if ident, ok := node.X.(*ast.Ident); ok && ident.Obj == nil {
+			// Unresolved identifiers are the packages, as long as nothing shadows them in a scope
+			// the parser doesn't see, which would only make the selector refer to something else.
+			if path, found := v.imports[ident.Name]; found && v.policy.ForbiddenSelectors[path][node.Sel.Name] {
+				v.violate(node.Pos(), RuleUnsafe, "Code may not use %v.%v", ident.Name, node.Sel.Name)
 			}
 		}
 	}
 	return v
 }
 
-func Validate(code string) error {
-	f, err := parser.ParseFile(&token.FileSet{}, "", code, 0)
+// checkRegister makes sure func main calls slave.Register.
+func (v *validator) checkRegister(f *ast.File) {
+	if f.Name.Name != "main" {
+		v.violate(f.Name.Pos(), RuleRegister, "Code has to be package main, not %v", f.Name.Name)
+		return
+	}
+	var main *ast.FuncDecl
+	for _, decl := range f.Decls {
+		if fun, ok := decl.(*ast.FuncDecl); ok && fun.Recv == nil && fun.Name.Name == "main" {
+			main = fun
+		}
+	}
+	if main == nil {
+		v.violate(f.Package, RuleRegister, "Code has no func main")
+		return
+	}
+	ast.Inspect(main, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Register" {
+				if ident, ok := sel.X.(*ast.Ident); ok && v.imports[ident.Name] == slave {
+					v.callsRegister = true
+				}
+			}
+		}
+		return !v.callsRegister
+	})
+	if !v.callsRegister {
+		v.violate(main.Pos(), RuleRegister, "func main has to call %v.Register", slave)
+	}
+}
+
+// Validate checks code against p, and returns an *Error with all violations found.
+func (p *Policy) Validate(code string) error {
+	v := &validator{
+		policy:  p,
+		fset:    token.NewFileSet(),
+		imports: map[string]string{},
+	}
+	if p.MaxFileSize > 0 && len(code) > p.MaxFileSize {
+		v.violate(token.NoPos, RuleFileSize, "Code may be at most %v bytes, is %v", p.MaxFileSize, len(code))
+		return &Error{Violations: v.violations}
+	}
+	f, err := parser.ParseFile(v.fset, "", code, parser.ParseComments)
 	if err != nil {
+		if list, ok := err.(scanner.ErrorList); ok {
+			for _, serr := range list {
+				v.violations = append(v.violations, Violation{
+					Rule:    RuleSyntax,
+					Line:    serr.Pos.Line,
+					Column:  serr.Pos.Column,
+					Message: serr.Msg,
+				})
+			}
+			return &Error{Violations: v.violations}
+		}
 		return err
 	}
-	v := &validator{}
+	for _, spec := range f.Imports {
+		v.checkImport(spec)
+	}
+	v.checkComments(f)
 	ast.Walk(v, f)
-	if len(v.disallowed) > 0 {
-		return fmt.Errorf("Code imports disallowed packages: %+v", v.disallowed)
+	if p.RequireRegister {
+		v.checkRegister(f)
 	}
-	if !v.importsSlave {
-		return fmt.Errorf("Code doesn't import required package %v", slave)
+	if len(v.violations) > 0 {
+		sort.SliceStable(v.violations, func(i, j int) bool {
+			a, b := v.violations[i], v.violations[j]
+			return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+		})
+		return &Error{Violations: v.violations}
 	}
 	return nil
 }
+
+// Validate checks code against DefaultPolicy.
+func Validate(code string) error {
+	return DefaultPolicy.Validate(code)
+}
@@ -0,0 +1,124 @@
+package validator
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const valid = `package main
+
+import (
+	"strings"
+
+	s "github.com/zond/hackyhack/proc/slave"
+)
+
+func main() {
+	s.Register(nil)
+	_ = strings.ToUpper("x")
+}
+`
+
+func TestDefaultCode(t *testing.T) {
+	for _, path := range []string{"../default/void.go", "../../lobby/default/handler.go"} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Validate(string(b)); err != nil {
+			t.Errorf("%v: %v", path, err)
+		}
+	}
+	if err := Validate(valid); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	for _, tc := range []struct {
+		code   string
+		rule   string
+		line   int
+		column int
+	}{
+		{
+			code:   strings.Replace(valid, `"strings"`, `"os"`, 1),
+			rule:   RuleImport,
+			line:   4,
+			column: 2,
+		},
+		{
+			code:   strings.Replace(valid, `"strings"`, `_ "unsafe"`, 1),
+			rule:   RuleUnsafe,
+			line:   4,
+			column: 2,
+		},
+		{
+			code:   strings.Replace(valid, `"strings"`, `"C"`, 1),
+			rule:   RuleCgo,
+			line:   4,
+			column: 2,
+		},
+		{
+			code:   strings.Replace(valid, "func main() {", "//go:noinline\nfunc main() {", 1),
+			rule:   RulePragma,
+			line:   9,
+			column: 1,
+		},
+		{
+			code:   strings.Replace(valid, "s.Register(nil)", "go func() {}()", 1),
+			rule:   RuleRegister,
+			line:   9,
+			column: 1,
+		},
+		{
+			code:   strings.Replace(valid, "func main() {", "func main() {\n\tif true {", 1),
+			rule:   RuleSyntax,
+			line:   13,
+			column: 3,
+		},
+	} {
+		err := Validate(tc.code)
+		verr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Wanted *Error for\n%v\ngot %#v", tc.code, err)
+			continue
+		}
+		found := false
+		for _, v := range verr.Violations {
+			if v.Rule == tc.rule && v.Line == tc.line && v.Column == tc.column {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Wanted %v violation at %v:%v for\n%v\ngot %+v", tc.rule, tc.line, tc.column, tc.code, verr.Violations)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	p := &Policy{
+		AllowedImports:  DefaultPolicy.AllowedImports,
+		MaxGoStatements: 1,
+		MaxFuncs:        2,
+		MaxFileSize:     len(valid) + 100,
+	}
+	code := strings.Replace(valid, "_ = strings", "go func() {}()\n\tgo func() {}()\n\t_ = strings", 1)
+	err, ok := p.Validate(code).(*Error)
+	if !ok {
+		t.Fatalf("Wanted *Error, got %#v", err)
+	}
+	rules := map[string]int{}
+	for _, v := range err.Violations {
+		rules[v.Rule]++
+	}
+	if rules[RuleGoStatements] != 1 || rules[RuleFuncs] != 1 {
+		t.Errorf("Wanted one go statement and one func violation, got %+v", err.Violations)
+	}
+
+	p.MaxFileSize = len(valid) - 1
+	if err, ok := p.Validate(valid).(*Error); !ok || err.Violations[0].Rule != RuleFileSize {
+		t.Errorf("Wanted file size violation, got %#v", err)
+	}
+}
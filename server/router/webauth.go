@@ -0,0 +1,43 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/session"
+	"github.com/zond/hackyhack/server/user"
+)
+
+// WebURL sets the base URL of the web server, used in the links returned by WebAuth.
+func (r *Router) WebURL(u string) *Router {
+	r.webURL = u
+	return r
+}
+
+// WebAuth returns a link that logs the user controlling the resource into the web server once.
+func (w *resourceWrapper) WebAuth() (string, *messages.Error) {
+	users := []user.User{}
+	if err := w.router.persister.Find(persist.NewF(user.User{
+		Resource: w.resource,
+	}).Add("Resource"), &users); err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("persister.Find failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	if len(users) == 0 {
+		return "", &messages.Error{
+			Message: "Only avatars can log into the web server.",
+			Code:    messages.ErrorCodeNotOwner,
+		}
+	}
+	token, err := session.CreateOneTime(w.router.persister, &users[0])
+	if err != nil {
+		return "", &messages.Error{
+			Message: fmt.Sprintf("Creating token failed: %v", err),
+			Code:    messages.ErrorCodeDatabase,
+		}
+	}
+	return fmt.Sprintf("%v/webauth/%v", w.router.webURL, token), nil
+}
@@ -4,9 +4,18 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/zond/hackyhack/proc/build"
+	"github.com/zond/hackyhack/proc/mcp"
+	"github.com/zond/hackyhack/server/challenge"
 	"github.com/zond/hackyhack/server/client"
 	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
 	"github.com/zond/hackyhack/server/router"
+	"github.com/zond/hackyhack/server/session"
+	"github.com/zond/hackyhack/server/state"
+	"github.com/zond/hackyhack/server/timer"
+	"github.com/zond/hackyhack/server/user"
 	"github.com/zond/hackyhack/server/web"
 )
 
@@ -20,19 +29,46 @@ type Server struct {
 	web       *web.Web
 }
 
-func New(p *persist.Persister) (*Server, error) {
-	r, err := router.New(p)
+func New(p *persist.Persister, c *build.Cache, sandbox *mcp.Sandbox) (*Server, error) {
+	if err := p.Index(user.User{}, "Username", "Resource"); err != nil {
+		return nil, err
+	}
+	if err := p.Index(resource.Resource{}, "Owner", "Container"); err != nil {
+		return nil, err
+	}
+	if err := p.Index(challenge.Challenge{}, "Attacker", "Target"); err != nil {
+		return nil, err
+	}
+	if err := p.Index(timer.Timer{}, "Resource", "At"); err != nil {
+		return nil, err
+	}
+	if err := p.Index(state.Entry{}, "Resource"); err != nil {
+		return nil, err
+	}
+	if err := p.Index(revision.Revision{}, "Resource"); err != nil {
+		return nil, err
+	}
+	if err := p.Index(session.Session{}, "Username"); err != nil {
+		return nil, err
+	}
+	r, err := router.New(p, c, sandbox)
 	if err != nil {
 		return nil, err
 	}
 	server := &Server{
 		persister: p,
 		router:    r,
-		web:       web.New(p, r),
+		web:       web.New(p, r, c),
 	}
 	return server, nil
 }
 
+// WebURL sets the base URL of the web server, used for links given to players.
+func (s *Server) WebURL(u string) *Server {
+	s.router.WebURL(u)
+	return s
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.web.ServeHTTP(w, r)
 }
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/user"
+)
+
+const (
+	// TTL is how long sessions are valid after login.
+	TTL = time.Hour * 24
+	// OneTimeTTL is how long one-time tokens may wait before being redeemed.
+	OneTimeTTL = time.Minute * 2
+	// tokenSize is the number of random bytes in a token.
+	tokenSize = 32
+)
+
+var ErrInvalid = fmt.Errorf("Invalid or expired session")
+
+// Session lets the holder of a token act as a user until ExpiresAt or until it's revoked.
+// Only hashes of the tokens are stored, so a leaked database doesn't leak sessions.
+type Session struct {
+	Id        string
+	Username  string
+	Resource  string
+	OneTime   bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func create(p *persist.Persister, u *user.User, oneTime bool, ttl time.Duration) (string, *Session, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	s := &Session{
+		Id:        hash(token),
+		Username:  u.Username,
+		Resource:  u.Resource,
+		OneTime:   oneTime,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := p.Put(s.Id, s); err != nil {
+		return "", nil, err
+	}
+	return token, s, nil
+}
+
+// Create starts a session for u, and returns its token.
+func Create(p *persist.Persister, u *user.User) (string, *Session, error) {
+	return create(p, u, false, TTL)
+}
+
+// CreateOneTime returns a token that can be redeemed once for a session for u.
+func CreateOneTime(p *persist.Persister, u *user.User) (string, error) {
+	token, _, err := create(p, u, true, OneTimeTTL)
+	return token, err
+}
+
+// get returns the unexpired session with token, and removes it if it's expired.
+func get(p *persist.Persister, token string) (*Session, error) {
+	s := &Session{}
+	if err := p.Get(hash(token), s); err == persist.ErrNotFound {
+		return nil, ErrInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.ExpiresAt) {
+		if err := p.Del(s.Id, s); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalid
+	}
+	return s, nil
+}
+
+// Get returns the session with token, unless it's a one-time token.
+func Get(p *persist.Persister, token string) (*Session, error) {
+	s, err := get(p, token)
+	if err != nil {
+		return nil, err
+	}
+	if s.OneTime {
+		return nil, ErrInvalid
+	}
+	return s, nil
+}
+
+// Redeem removes the one-time token, and starts a session for its user.
+func Redeem(p *persist.Persister, token string) (string, *Session, error) {
+	var result *Session
+	var resultToken string
+	if err := p.Transact(func(p *persist.Persister) error {
+		s, err := get(p, token)
+		if err != nil {
+			return err
+		}
+		if !s.OneTime {
+			return ErrInvalid
+		}
+		if err := p.Del(s.Id, s); err != nil {
+			return err
+		}
+		resultToken, result, err = create(p, &user.User{
+			Username: s.Username,
+			Resource: s.Resource,
+		}, false, TTL)
+		return err
+	}); err != nil {
+		return "", nil, err
+	}
+	return resultToken, result, nil
+}
+
+// Revoke ends the session with token.
+func Revoke(p *persist.Persister, token string) error {
+	s := &Session{}
+	if err := p.Del(hash(token), s); err != nil && err != persist.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// RevokeAll ends all sessions of username.
+func RevokeAll(p *persist.Persister, username string) error {
+	return p.Transact(func(p *persist.Persister) error {
+		sessions := []Session{}
+		if err := p.Find(persist.NewF(Session{
+			Username: username,
+		}).Add("Username"), &sessions); err != nil {
+			return err
+		}
+		for index := range sessions {
+			if err := p.Del(sessions[index].Id, &sessions[index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Sweep removes the sessions expired at now, since get only removes those that are used again.
+func Sweep(p *persist.Persister, now time.Time) error {
+	return p.Transact(func(p *persist.Persister) error {
+		sessions := []Session{}
+		if err := p.Find(persist.NewF(Session{}).AddRange("ExpiresAt", nil, now), &sessions); err != nil {
+			return err
+		}
+		for index := range sessions {
+			if err := p.Del(sessions[index].Id, &sessions[index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/user"
+)
+
+func newPersister(t *testing.T) *persist.Persister {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if err := p.Index(Session{}, "Username"); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSessions(t *testing.T) {
+	p := newPersister(t)
+	u := &user.User{Username: "bob", Resource: "r"}
+	token, _, err := Create(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Get(p, token); err != nil || s.Username != "bob" {
+		t.Fatalf("Got %+v, %v", s, err)
+	}
+	if _, err := Get(p, "not"+token); err != ErrInvalid {
+		t.Errorf("Got %v, wanted %v", err, ErrInvalid)
+	}
+	if err := Revoke(p, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(p, token); err != ErrInvalid {
+		t.Errorf("Got %v, wanted %v", err, ErrInvalid)
+	}
+}
+
+func TestOneTime(t *testing.T) {
+	p := newPersister(t)
+	u := &user.User{Username: "bob", Resource: "r"}
+	oneTime, err := CreateOneTime(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(p, oneTime); err != ErrInvalid {
+		t.Errorf("Wanted one-time tokens unusable as sessions, got %v", err)
+	}
+	token, s, err := Redeem(p, oneTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.OneTime || s.Resource != "r" {
+		t.Errorf("Got %+v", s)
+	}
+	if _, _, err := Redeem(p, oneTime); err != ErrInvalid {
+		t.Errorf("Wanted one-time tokens to work once, got %v", err)
+	}
+	if _, _, err := Redeem(p, token); err != ErrInvalid {
+		t.Errorf("Wanted sessions not to be redeemable, got %v", err)
+	}
+}
+
+func TestExpiryAndRevokeAll(t *testing.T) {
+	p := newPersister(t)
+	u := &user.User{Username: "bob", Resource: "r"}
+	token, s, err := Create(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ExpiresAt = time.Now().Add(-time.Second)
+	if err := p.Put(s.Id, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(p, token); err != ErrInvalid {
+		t.Errorf("Got %v, wanted %v", err, ErrInvalid)
+	}
+
+	first, _, err := Create(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := Create(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeAll(p, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{first, second} {
+		if _, err := Get(p, token); err != ErrInvalid {
+			t.Errorf("Got %v, wanted %v", err, ErrInvalid)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	p := newPersister(t)
+	u := &user.User{Username: "bob", Resource: "r"}
+	token, _, err := Create(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oneTime, err := CreateOneTime(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sweep(p, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(p, token); err != nil {
+		t.Errorf("Got %v, wanted unexpired sessions kept", err)
+	}
+
+	if err := Sweep(p, time.Now().Add(OneTimeTTL+time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Redeem(p, oneTime); err != ErrInvalid {
+		t.Errorf("Got %v, wanted expired one-time tokens swept", err)
+	}
+	if _, err := Get(p, token); err != nil {
+		t.Errorf("Got %v, wanted unexpired sessions kept", err)
+	}
+
+	if err := Sweep(p, time.Now().Add(TTL+time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	left := []Session{}
+	if err := p.Find(persist.NewF(Session{
+		Username: "bob",
+	}).Add("Username"), &left); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("Got %+v, wanted all sessions swept", left)
+	}
+}
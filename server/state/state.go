@@ -0,0 +1,123 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zond/hackyhack/server/account"
+	"github.com/zond/hackyhack/server/persist"
+)
+
+const (
+	// MaxKeySize is the longest key a resource can store state under.
+	MaxKeySize = 256
+	// MaxValueSize is the largest value a resource can store under one key.
+	MaxValueSize = 1 << 16
+)
+
+var (
+	ErrKeySize   = fmt.Errorf("Keys can't be empty or longer than %v bytes", MaxKeySize)
+	ErrValueSize = fmt.Errorf("Values can't be longer than %v bytes", MaxValueSize)
+)
+
+// Entry is a value stored by Resource under Key, charged to Owner.
+type Entry struct {
+	Id        string
+	Resource  string
+	Owner     string
+	Key       string
+	Value     string
+	UpdatedAt time.Time
+	CreatedAt time.Time
+}
+
+func (e *Entry) size() int64 {
+	return int64(len(e.Key) + len(e.Value))
+}
+
+func entryId(resource, key string) string {
+	return resource + "/" + key
+}
+
+func Get(p *persist.Persister, resource, key string) (*Entry, error) {
+	entry := &Entry{}
+	if err := p.Get(entryId(resource, key), entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Set stores value under key for resource, and charges the size to owner.
+func Set(p *persist.Persister, owner, resource, key, value string) error {
+	if key == "" || len(key) > MaxKeySize {
+		return ErrKeySize
+	}
+	if len(value) > MaxValueSize {
+		return ErrValueSize
+	}
+	return p.Transact(func(p *persist.Persister) error {
+		now := time.Now()
+		oldOwner, oldSize := owner, int64(0)
+		entry, err := Get(p, resource, key)
+		if err == persist.ErrNotFound {
+			entry = &Entry{
+				Id:        entryId(resource, key),
+				Resource:  resource,
+				Key:       key,
+				CreatedAt: now,
+			}
+		} else if err != nil {
+			return err
+		} else {
+			oldOwner, oldSize = entry.Owner, entry.size()
+		}
+		entry.Owner = owner
+		entry.Value = value
+		entry.UpdatedAt = now
+		// Charge before refunding, so that nothing changes if the owner is over quota.
+		if oldOwner == owner {
+			if _, err := account.ChargeStorage(p, owner, entry.size()-oldSize); err != nil {
+				return err
+			}
+		} else {
+			if _, err := account.ChargeStorage(p, owner, entry.size()); err != nil {
+				return err
+			}
+			if _, err := account.ChargeStorage(p, oldOwner, -oldSize); err != nil {
+				return err
+			}
+		}
+		return p.Put(entry.Id, entry)
+	})
+}
+
+// Del removes key from the state of resource, and refunds the owner that stored it.
+func Del(p *persist.Persister, resource, key string) error {
+	return p.Transact(func(p *persist.Persister) error {
+		entry, err := Get(p, resource, key)
+		if err != nil {
+			return err
+		}
+		if _, err := account.ChargeStorage(p, entry.Owner, -entry.size()); err != nil {
+			return err
+		}
+		return p.Del(entry.Id, entry)
+	})
+}
+
+// Keys returns the sorted keys resource has stored state under.
+func Keys(p *persist.Persister, resource string) ([]string, error) {
+	entries := []Entry{}
+	if err := p.Find(persist.NewF(Entry{
+		Resource: resource,
+	}).Add("Resource"), &entries); err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(entries))
+	for index := range entries {
+		keys[index] = entries[index].Key
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
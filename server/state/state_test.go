@@ -0,0 +1,100 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zond/hackyhack/server/account"
+	"github.com/zond/hackyhack/server/persist"
+)
+
+func storage(t *testing.T, p *persist.Persister, owner string) int64 {
+	acc, err := account.Get(p, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acc.Storage
+}
+
+func TestSetCharges(t *testing.T) {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if err := Set(p, "owner", "res", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage(t, p, "owner"); got != int64(len("key")+len("value")) {
+		t.Errorf("Got storage %v, wanted %v", got, len("key")+len("value"))
+	}
+
+	// Overwriting only charges the difference.
+	if err := Set(p, "owner", "res", "key", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage(t, p, "owner"); got != int64(len("key")+len("v")) {
+		t.Errorf("Got storage %v after overwrite, wanted %v", got, len("key")+len("v"))
+	}
+
+	// Overwriting as a new owner moves the charge.
+	if err := Set(p, "newOwner", "res", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage(t, p, "owner"); got != 0 {
+		t.Errorf("Got storage %v for the old owner, wanted 0", got)
+	}
+	if got := storage(t, p, "newOwner"); got != int64(len("key")+len("value")) {
+		t.Errorf("Got storage %v for the new owner, wanted %v", got, len("key")+len("value"))
+	}
+
+	if err := Del(p, "res", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage(t, p, "newOwner"); got != 0 {
+		t.Errorf("Got storage %v after delete, wanted 0", got)
+	}
+	if err := Del(p, "res", "key"); err != persist.ErrNotFound {
+		t.Errorf("Got %v deleting twice, wanted %v", err, persist.ErrNotFound)
+	}
+}
+
+func TestSetOverQuota(t *testing.T) {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if _, err := account.ChargeStorage(p, "owner", account.StorageQuota-10); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(p, "owner", "res", "key", "small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(p, "owner", "res", "other", "too large"); err != account.ErrStorageQuota {
+		t.Errorf("Got %v, wanted %v", err, account.ErrStorageQuota)
+	}
+	if _, err := Get(p, "res", "other"); err != persist.ErrNotFound {
+		t.Errorf("Got %v, wanted nothing stored over the quota", err)
+	}
+	// Shrinking a value is fine even close to the quota.
+	if err := Set(p, "owner", "res", "key", "s"); err != nil {
+		t.Errorf("Got %v shrinking, wanted no error", err)
+	}
+	if got := storage(t, p, "owner"); got != account.StorageQuota-10+int64(len("key")+len("s")) {
+		t.Errorf("Got storage %v, wanted %v", got, account.StorageQuota-10+len("key")+len("s"))
+	}
+
+	// The house has no quota.
+	if err := Set(p, "", "res", "house", strings.Repeat("x", MaxValueSize)); err != nil {
+		t.Errorf("Got %v, wanted the house to store anything", err)
+	}
+}
+
+func TestSetSizes(t *testing.T) {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if err := Set(p, "owner", "res", "", "value"); err != ErrKeySize {
+		t.Errorf("Got %v, wanted %v", err, ErrKeySize)
+	}
+	if err := Set(p, "owner", "res", strings.Repeat("k", MaxKeySize+1), "value"); err != ErrKeySize {
+		t.Errorf("Got %v, wanted %v", err, ErrKeySize)
+	}
+	if err := Set(p, "owner", "res", "key", strings.Repeat("v", MaxValueSize+1)); err != ErrValueSize {
+		t.Errorf("Got %v, wanted %v", err, ErrValueSize)
+	}
+	if got := storage(t, p, "owner"); got != 0 {
+		t.Errorf("Got storage %v, wanted nothing charged for refused values", got)
+	}
+}
@@ -0,0 +1,346 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Commands and options from RFC 854, 857 (ECHO), 858 (SGA), 1073 (NAWS) and 1091 (TTYPE), and
+// the GMCP option used by MUD clients.
+const (
+	SE   = 240
+	NOP  = 241
+	EC   = 247
+	EL   = 248
+	GA   = 249
+	SB   = 250
+	WILL = 251
+	WONT = 252
+	DO   = 253
+	DONT = 254
+	IAC  = 255
+
+	OptEcho  = 1
+	OptSGA   = 3
+	OptTType = 24
+	OptNAWS  = 31
+	OptGMCP  = 201
+
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+const (
+	// maxLine is how long lines may get before being cut, to keep clients from eating memory.
+	maxLine = 1 << 14
+	// maxSubneg is how long subnegotiations may get before being ignored.
+	maxSubneg = 256
+)
+
+var ErrGMCPDisabled = fmt.Errorf("Client doesn't support GMCP")
+
+// Terminal is what the client told us about its terminal. Zero values mean it didn't say.
+type Terminal struct {
+	Type   string
+	Width  int
+	Height int
+	GMCP   bool
+}
+
+// Conn sits between a net.Conn and line based handlers, parsing and answering telnet commands,
+// and escaping output.
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+	lock      sync.RWMutex
+	terminal  Terminal
+	// remote are the options we know the client will use, local the ones we will use, and offered
+	// the ones we will use if the client agrees.
+	remote  map[byte]bool
+	local   map[byte]bool
+	offered map[byte]bool
+	// lastCR is whether the last byte read ended a line with CR, so that the LF or NUL following it
+	// doesn't end another.
+	lastCR bool
+}
+
+func New(conn net.Conn) *Conn {
+	return &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		remote:  map[byte]bool{},
+		local:   map[byte]bool{},
+		offered: map[byte]bool{},
+	}
+}
+
+// Negotiate asks the client to tell us its window size and terminal type, and offers GMCP.
+func (c *Conn) Negotiate() error {
+	c.lock.Lock()
+	c.offered[OptGMCP] = true
+	c.lock.Unlock()
+	return c.command(DO, OptNAWS, DO, OptTType, WILL, OptGMCP)
+}
+
+func (c *Conn) command(b ...byte) error {
+	cmd := []byte{}
+	for i := 0; i+1 < len(b); i += 2 {
+		cmd = append(cmd, IAC, b[i], b[i+1])
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(cmd)
+	return err
+}
+
+// Write writes b with IAC escaped and line endings translated to CR LF.
+func (c *Conn) Write(b []byte) (int, error) {
+	buf := &bytes.Buffer{}
+	for index, by := range b {
+		switch by {
+		case IAC:
+			buf.Write([]byte{IAC, IAC})
+		case '\n':
+			if index == 0 || b[index-1] != '\r' {
+				buf.WriteByte('\r')
+			}
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(by)
+		}
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetEcho turns echoing of input by the client on or off, by claiming to do the echoing ourselves
+// while it's off.
+func (c *Conn) SetEcho(echo bool) error {
+	c.lock.Lock()
+	if c.local[OptEcho] == !echo {
+		c.lock.Unlock()
+		return nil
+	}
+	c.local[OptEcho] = !echo
+	c.lock.Unlock()
+	if echo {
+		return c.command(WONT, OptEcho)
+	}
+	return c.command(WILL, OptEcho)
+}
+
+func (c *Conn) Terminal() Terminal {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	result := c.terminal
+	result.GMCP = c.local[OptGMCP]
+	return result
+}
+
+// SendGMCP sends a GMCP message, which is the package name followed by a JSON payload.
+func (c *Conn) SendGMCP(pkg string, payload []byte) error {
+	c.lock.RLock()
+	enabled := c.local[OptGMCP]
+	c.lock.RUnlock()
+	if !enabled {
+		return ErrGMCPDisabled
+	}
+	buf := bytes.NewBuffer([]byte{IAC, SB, OptGMCP})
+	for _, b := range append([]byte(pkg+" "), payload...) {
+		if b == IAC {
+			buf.WriteByte(IAC)
+		}
+		buf.WriteByte(b)
+	}
+	buf.Write([]byte{IAC, SE})
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadLine returns the next line of input, without line ending, after handling any telnet commands
+// before it. Lines end with CR LF, CR NUL, CR or LF, and erase characters and commands are applied.
+func (c *Conn) ReadLine() (string, error) {
+	line := []byte{}
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		lastCR := c.lastCR
+		c.lastCR = false
+		switch b {
+		case IAC:
+			cmd, err := c.handleCommand()
+			if err != nil {
+				return "", err
+			}
+			switch cmd {
+			case IAC:
+				if len(line) < maxLine {
+					line = append(line, IAC)
+				}
+			case EC:
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+				}
+			case EL:
+				line = line[:0]
+			}
+		case '\r':
+			c.lastCR = true
+			return string(line), nil
+		case '\n':
+			if lastCR {
+				continue
+			}
+			return string(line), nil
+		case 0:
+			// Only meaningful after CR, as in CR NUL.
+		case '\b', 127:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			if len(line) < maxLine {
+				line = append(line, b)
+			}
+		}
+	}
+}
+
+// handleCommand handles the command after an IAC, and returns the command if it's an erase
+// command or a literal IAC.
+func (c *Conn) handleCommand() (byte, error) {
+	cmd, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch cmd {
+	case WILL, WONT, DO, DONT:
+		opt, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return 0, c.handleOption(cmd, opt)
+	case SB:
+		return 0, c.handleSubnegotiation()
+	case EC, EL, IAC:
+		return cmd, nil
+	}
+	// NOP, GA, AYT and friends don't matter to a line based server.
+	return 0, nil
+}
+
+// handleOption answers negotiations, only answering when the state changes to avoid loops.
+func (c *Conn) handleOption(cmd, opt byte) error {
+	c.lock.Lock()
+	var reply []byte
+	switch cmd {
+	case WILL:
+		switch {
+		case c.remote[opt]:
+		case opt == OptNAWS:
+			c.remote[opt] = true
+		case opt == OptTType:
+			c.remote[opt] = true
+			// Ask for the type right away.
+			reply = []byte{IAC, SB, OptTType, ttypeSend, IAC, SE}
+		default:
+			reply = []byte{IAC, DONT, opt}
+		}
+	case WONT:
+		if c.remote[opt] {
+			c.remote[opt] = false
+			reply = []byte{IAC, DONT, opt}
+		}
+	case DO:
+		switch {
+		case c.local[opt]:
+		case c.offered[opt]:
+			delete(c.offered, opt)
+			c.local[opt] = true
+		case opt == OptSGA:
+			c.local[opt] = true
+			reply = []byte{IAC, WILL, opt}
+		default:
+			// Echo is only agreed to when we offer it, in SetEcho.
+			reply = []byte{IAC, WONT, opt}
+		}
+	case DONT:
+		if c.offered[opt] {
+			delete(c.offered, opt)
+		} else if c.local[opt] {
+			c.local[opt] = false
+			reply = []byte{IAC, WONT, opt}
+		}
+	}
+	c.lock.Unlock()
+	if reply == nil {
+		return nil
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(reply)
+	return err
+}
+
+// handleSubnegotiation reads the subnegotiation up to IAC SE, and records NAWS and TTYPE IS.
+func (c *Conn) handleSubnegotiation() error {
+	data := []byte{}
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == IAC {
+			next, err := c.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			if next == SE {
+				break
+			}
+			// IAC IAC is a literal 255, like in window sizes of 255.
+			b = next
+		}
+		if len(data) < maxSubneg {
+			data = append(data, b)
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch data[0] {
+	case OptNAWS:
+		if len(data) == 5 {
+			c.terminal.Width = int(binary.BigEndian.Uint16(data[1:3]))
+			c.terminal.Height = int(binary.BigEndian.Uint16(data[3:5]))
+		}
+	case OptTType:
+		if len(data) > 1 && data[1] == ttypeIs {
+			c.terminal.Type = string(data[2:])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package telnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// timeout is how long tests wait for anything, so that a broken Conn fails them instead of hanging them.
+const timeout = 5 * time.Second
+
+// pipe returns a Conn reading input, and the other end of it.
+func pipe(t *testing.T) (*Conn, net.Conn) {
+	server, client := net.Pipe()
+	client.SetDeadline(time.Now().Add(timeout))
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return New(server), client
+}
+
+// async runs f in a goroutine, and returns a channel getting its error.
+func async(f func() error) chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- f()
+	}()
+	return result
+}
+
+func await(t *testing.T, result chan error) {
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("Timed out")
+	}
+}
+
+// readLine reads a line from c in a goroutine, and returns a function waiting for it.
+func readLine(t *testing.T, c *Conn) func() string {
+	var line string
+	result := async(func() (err error) {
+		line, err = c.ReadLine()
+		return
+	})
+	return func() string {
+		await(t, result)
+		return line
+	}
+}
+
+// expect reads exactly as many bytes as want from conn, and checks that they are want.
+func expect(t *testing.T, conn net.Conn, want []byte) {
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Got %q and %v, wanted %q", got, err, want)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Got %q, wanted %q", got, want)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	c, client := pipe(t)
+	written := async(func() error {
+		_, err := client.Write([]byte("hello\r\nworx\bld\r\x00x\xff\xf8y\xff\xffz\nlast\r\n"))
+		return err
+	})
+	for _, want := range []string{"hello", "world", "y\xffz", "last"} {
+		got, err := c.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Got %q, wanted %q", got, want)
+		}
+	}
+	await(t, written)
+}
+
+func TestNegotiation(t *testing.T) {
+	c, client := pipe(t)
+	line := readLine(t, c)
+	// Writes to a pipe return once the Conn has read them, so the Conn is waiting for us to read its
+	// answer after each of them.
+	if _, err := client.Write([]byte{IAC, WILL, OptNAWS, IAC, SB, OptNAWS, 0, 100, 0, 40, IAC, SE, IAC, WILL, OptTType}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, client, []byte{IAC, SB, OptTType, ttypeSend, IAC, SE})
+	if _, err := client.Write(append(append([]byte{IAC, SB, OptTType, ttypeIs}, "XTERM"...), IAC, SE, IAC, DO, 42)); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, client, []byte{IAC, WONT, 42})
+	if _, err := client.Write([]byte("\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := line(); got != "" {
+		t.Errorf("Got %q, wanted an empty line", got)
+	}
+	if got, want := c.Terminal(), (Terminal{Type: "XTERM", Width: 100, Height: 40}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, wanted %+v", got, want)
+	}
+}
+
+func TestWriteAndEcho(t *testing.T) {
+	c, client := pipe(t)
+	done := async(func() error {
+		if _, err := c.Write([]byte("a\nb\r\n\xff")); err != nil {
+			return err
+		}
+		for _, echo := range []bool{false, false, true} {
+			if err := c.SetEcho(echo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// Turning echo off twice only tells the client once.
+	expect(t, client, append([]byte("a\r\nb\r\n"), IAC, IAC, IAC, WILL, OptEcho, IAC, WONT, OptEcho))
+	await(t, done)
+}
+
+func TestGMCP(t *testing.T) {
+	c, client := pipe(t)
+	done := async(c.Negotiate)
+	expect(t, client, []byte{IAC, DO, OptNAWS, IAC, DO, OptTType, IAC, WILL, OptGMCP})
+	await(t, done)
+	if err := c.SendGMCP("Room.Info", []byte("{}")); err != ErrGMCPDisabled {
+		t.Errorf("Got %v, wanted %v", err, ErrGMCPDisabled)
+	}
+
+	line := readLine(t, c)
+	if _, err := client.Write([]byte{IAC, DO, OptGMCP, '\r', '\n'}); err != nil {
+		t.Fatal(err)
+	}
+	line()
+	if !c.Terminal().GMCP {
+		t.Errorf("Wanted GMCP enabled")
+	}
+
+	done = async(func() error {
+		return c.SendGMCP("Char.Vitals", []byte("\"\xff\""))
+	})
+	want := append([]byte{IAC, SB, OptGMCP}, []byte("Char.Vitals \"\xff\xff\"")...)
+	expect(t, client, append(want, IAC, SE))
+	await(t, done)
+}
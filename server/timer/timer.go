@@ -0,0 +1,47 @@
+package timer
+
+import (
+	"time"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+)
+
+// Timer calls Method of Resource At, and then every Interval if Interval is set.
+type Timer struct {
+	Id        string
+	Resource  string
+	Method    string
+	At        time.Time
+	Interval  time.Duration
+	CreatedAt time.Time
+}
+
+func (t *Timer) ToMessage() *messages.Timer {
+	return &messages.Timer{
+		Id:       t.Id,
+		Method:   t.Method,
+		At:       t.At,
+		Interval: t.Interval,
+	}
+}
+
+// Due returns the timers that should have fired by now, using the index on At where there is one.
+func Due(p *persist.Persister, now time.Time) ([]Timer, error) {
+	timers := []Timer{}
+	if err := p.Find(persist.NewF(Timer{}).AddRange("At", nil, now.Add(time.Nanosecond)).Order("At", false), &timers); err != nil {
+		return nil, err
+	}
+	return timers, nil
+}
+
+// ForResource returns the timers of resource, soonest first.
+func ForResource(p *persist.Persister, resource string) ([]Timer, error) {
+	timers := []Timer{}
+	if err := p.Find(persist.NewF(Timer{
+		Resource: resource,
+	}).Add("Resource").Order("At", false), &timers); err != nil {
+		return nil, err
+	}
+	return timers, nil
+}
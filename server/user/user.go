@@ -1,8 +1,10 @@
 package user
 
 type User struct {
-	Username  string
-	Password  string
-	Resource  string
-	Container string
+	Username string
+	// Password is the plaintext password of users who haven't logged in since passwords were hashed.
+	Password     string
+	PasswordHash string
+	Resource     string
+	Container    string
 }
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zond/hackyhack/server/router/validator"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+const (
+	SourceGoimports = "goimports"
+	SourceValidator = "validator"
+	SourceBuild     = "build"
+	SourceVet       = "vet"
+)
+
+// Diagnostic is a problem found in code, positioned so that the editor can annotate it.
+// Line and Column start at 1, and are 0 for problems without a position.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	Source   string
+	Message  string
+}
+
+// Checked is what checking code found. Code is the code the diagnostics are positioned in, which
+// is the code as stored, since goimports may have rewritten the code as sent.
+type Checked struct {
+	Code        string
+	Diagnostics []Diagnostic
+}
+
+// diagnosticsErr makes the authenticated handler respond with the diagnostics as JSON.
+type diagnosticsErr struct {
+	status      int
+	code        string
+	diagnostics []Diagnostic
+}
+
+func (d diagnosticsErr) Error() string {
+	lines := make([]string, len(d.diagnostics))
+	for index, diag := range d.diagnostics {
+		lines[index] = fmt.Sprintf("%v:%v:%v: %v", diag.File, diag.Line, diag.Column, diag.Message)
+	}
+	return fmt.Sprintf("%v: %v", strings.Join(lines, "\n"), d.status)
+}
+
+func writeDiagnostics(w http.ResponseWriter, status int, code string, diagnostics []Diagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(Checked{
+		Code:        code,
+		Diagnostics: diagnostics,
+	})
+}
+
+// toolLineReg matches the "file:line:column: message" and "file:line: message" lines of the go tools.
+var toolLineReg = regexp.MustCompile(`^(.+?\.go):(\d+)(?::(\d+))?:\s*(.*)$`)
+
+// parseToolOutput turns the output of a go tool run on a single file into diagnostics for file.
+// Indented lines continue the message of the line before, and other lines without position become
+// diagnostics without position.
+func parseToolOutput(output, file, source, severity string) []Diagnostic {
+	result := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(result) > 0 {
+			result[len(result)-1].Message += "\n" + strings.TrimSpace(line)
+			continue
+		}
+		diag := Diagnostic{
+			File:     file,
+			Severity: severity,
+			Source:   source,
+			Message:  strings.TrimSpace(line),
+		}
+		// The tools run on copies in other places, so only the positions are kept.
+		if match := toolLineReg.FindStringSubmatch(line); match != nil {
+			diag.Line, _ = strconv.Atoi(match[2])
+			diag.Column, _ = strconv.Atoi(match[3])
+			diag.Message = match[4]
+		}
+		result = append(result, diag)
+	}
+	return result
+}
+
+// validationDiagnostics turns errors from validator.Validate into diagnostics for file.
+func validationDiagnostics(err error, file string) []Diagnostic {
+	verr, ok := err.(*validator.Error)
+	if !ok {
+		return []Diagnostic{
+			{
+				File:     file,
+				Severity: SeverityError,
+				Source:   SourceValidator,
+				Message:  err.Error(),
+			},
+		}
+	}
+	result := make([]Diagnostic, len(verr.Violations))
+	for index, violation := range verr.Violations {
+		result[index] = Diagnostic{
+			File:     file,
+			Line:     violation.Line,
+			Column:   violation.Column,
+			Severity: SeverityError,
+			Source:   SourceValidator,
+			Message:  fmt.Sprintf("%v (%v)", violation.Message, violation.Rule),
+		}
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseToolOutput(t *testing.T) {
+	output := `# command-line-arguments
+/tmp/hackyhack-build/abc.go:12:3: undefined: foo
+/tmp/hackyhack-build/abc.go:14: missing return
+	have (string)
+	want (int)
+go: something without position
+`
+	want := []Diagnostic{
+		{File: "r.go", Line: 12, Column: 3, Severity: SeverityError, Source: SourceBuild, Message: "undefined: foo"},
+		{File: "r.go", Line: 14, Column: 0, Severity: SeverityError, Source: SourceBuild, Message: "missing return\nhave (string)\nwant (int)"},
+		{File: "r.go", Severity: SeverityError, Source: SourceBuild, Message: "go: something without position"},
+	}
+	if got := parseToolOutput(output, "r.go", SourceBuild, SeverityError); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, wanted %+v", got, want)
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := writeDiagnostics(rec, 400, "package main\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 400 {
+		t.Errorf("Got status %v, wanted 400", rec.Code)
+	}
+	checked := &Checked{}
+	if err := json.NewDecoder(rec.Body).Decode(checked); err != nil {
+		t.Fatal(err)
+	}
+	if checked.Code != "package main\n" || checked.Diagnostics == nil || len(checked.Diagnostics) != 0 {
+		t.Errorf("Got %+v, wanted the code and no diagnostics", checked)
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+)
+
+// streamLogs sends the kept log lines of the resource, and then new ones as they are logged, as server-sent events.
+func (web *Web) streamLogs(c *context) error {
+	res := &resource.Resource{}
+	if err := web.persister.Get(c.vars["resource"], res); err == persist.ErrNotFound {
+		return webErr{status: 404, body: err.Error()}
+	} else if err != nil {
+		return err
+	}
+
+	if res.Owner != c.user.Resource {
+		return webErr{status: 403, body: "Not owner"}
+	}
+
+	flusher, ok := c.resp.(http.Flusher)
+	if !ok {
+		return webErr{status: 500, body: "Streaming unsupported"}
+	}
+
+	lines, ch, cancel := web.hackRouter.Logs().Subscribe(res.Id)
+	defer cancel()
+
+	c.resp.Header().Set("Content-Type", "text/event-stream")
+	c.resp.Header().Set("Cache-Control", "no-cache")
+
+	send := func(line messages.LogLine) error {
+		b, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.resp, "data: %s\n\n", b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	for _, line := range lines {
+		if err := send(line); err != nil {
+			return err
+		}
+	}
+	if len(lines) == 0 {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case line := <-ch:
+			if err := send(line); err != nil {
+				return err
+			}
+		case <-c.req.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/zond/hackyhack/server/client"
+	"github.com/zond/hackyhack/server/telnet"
+)
+
+const (
+	PlayInput  = "input"
+	PlayResize = "resize"
+	PlayOutput = "output"
+	PlayEcho   = "echo"
+	PlayGMCP   = "gmcp"
+)
+
+const (
+	// maxPlayMessage is how large messages from players may get before their sockets are closed.
+	maxPlayMessage = 1 << 16
+)
+
+// PlayMessage is sent both ways over play sockets. Players send input lines and the size of their
+// terminal, and get output, echo changes and GMCP messages back.
+type PlayMessage struct {
+	Type    string
+	Text    string          `json:",omitempty"`
+	Echo    bool            `json:",omitempty"`
+	Width   int             `json:",omitempty"`
+	Height  int             `json:",omitempty"`
+	Package string          `json:",omitempty"`
+	Payload json.RawMessage `json:",omitempty"`
+}
+
+var playUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// playConn makes a web socket look like a telnet connection to client.Client.
+type playConn struct {
+	socket    *websocket.Conn
+	writeLock sync.Mutex
+	lock      sync.RWMutex
+	terminal  telnet.Terminal
+}
+
+func (p *playConn) send(msg *PlayMessage) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	return p.socket.WriteJSON(msg)
+}
+
+func (p *playConn) Write(b []byte) (int, error) {
+	if err := p.send(&PlayMessage{
+		Type: PlayOutput,
+		Text: string(b),
+	}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadLine returns the next input line, after recording any resizes before it.
+func (p *playConn) ReadLine() (string, error) {
+	for {
+		msg := &PlayMessage{}
+		if err := p.socket.ReadJSON(msg); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return "", io.EOF
+			}
+			return "", err
+		}
+		switch msg.Type {
+		case PlayInput:
+			return msg.Text, nil
+		case PlayResize:
+			p.lock.Lock()
+			p.terminal.Width = msg.Width
+			p.terminal.Height = msg.Height
+			p.lock.Unlock()
+		}
+	}
+}
+
+// SetEcho tells the page to show or hide what the player types.
+func (p *playConn) SetEcho(echo bool) error {
+	return p.send(&PlayMessage{
+		Type: PlayEcho,
+		Echo: echo,
+	})
+}
+
+func (p *playConn) Terminal() telnet.Terminal {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.terminal
+}
+
+// SendGMCP forwards GMCP messages to the page, which has the payload as JSON already.
+func (p *playConn) SendGMCP(pkg string, payload []byte) error {
+	return p.send(&PlayMessage{
+		Type:    PlayGMCP,
+		Package: pkg,
+		Payload: payload,
+	})
+}
+
+// play serves the terminal page.
+func (web *Web) play(w http.ResponseWriter, r *http.Request) {
+	if requireTLS(w, r) {
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(
+		os.Getenv("GOPATH"),
+		"src",
+		"github.com",
+		"zond",
+		"hackyhack",
+		"server",
+		"web",
+		"static",
+		"play.html",
+	))
+}
+
+// playSocket connects a web socket to the lobby, just like the login listener does with telnet
+// connections. The upgrader refuses requests from other origins, so other sites can't play for
+// their visitors.
+func (web *Web) playSocket(w http.ResponseWriter, r *http.Request) {
+	if requireTLS(w, r) {
+		return
+	}
+	socket, err := playUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded.
+		log.Printf("Upgrading to web socket failed: %v", err)
+		return
+	}
+	defer socket.Close()
+	socket.SetReadLimit(maxPlayMessage)
+	conn := &playConn{
+		socket: socket,
+		terminal: telnet.Terminal{
+			Type: "websocket",
+			GMCP: true,
+		},
+	}
+	client.New(web.persister, web.hackRouter).Serve(conn)
+}
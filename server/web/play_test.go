@@ -0,0 +1,80 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPlayConn(t *testing.T) {
+	conns := make(chan *playConn)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := playUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer socket.Close()
+		conns <- &playConn{socket: socket}
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	conn := <-conns
+
+	for _, msg := range []PlayMessage{
+		{Type: PlayResize, Width: 80, Height: 24},
+		{Type: PlayInput, Text: "login bob"},
+	} {
+		if err := socket.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if line, err := conn.ReadLine(); err != nil || line != "login bob" {
+		t.Errorf("Got %q, %v, wanted \"login bob\"", line, err)
+	}
+	if term := conn.Terminal(); term.Width != 80 || term.Height != 24 {
+		t.Errorf("Got %+v, wanted 80x24", term)
+	}
+
+	if _, err := io.WriteString(conn, "Password: "); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetEcho(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendGMCP("Room.Info", []byte(`{"name":"void"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []PlayMessage{
+		{Type: PlayOutput, Text: "Password: "},
+		{Type: PlayEcho},
+		{Type: PlayGMCP, Package: "Room.Info", Payload: []byte(`{"name":"void"}`)},
+	} {
+		got := PlayMessage{}
+		if err := socket.ReadJSON(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != want.Type || got.Text != want.Text || got.Echo != want.Echo || got.Package != want.Package || string(got.Payload) != string(want.Payload) {
+			t.Errorf("Got %+v, wanted %+v", got, want)
+		}
+	}
+
+	if err := socket.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ReadLine(); err != io.EOF {
+		t.Errorf("Got %v, wanted EOF", err)
+	}
+}
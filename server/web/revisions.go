@@ -0,0 +1,108 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
+)
+
+type revisionSummary struct {
+	Number    int
+	Author    string
+	CodeHash  string
+	Binary    string
+	Broken    bool
+	CreatedAt time.Time
+}
+
+func (web *Web) revision(resource, number string) (*revision.Revision, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, webErr{status: 400, body: fmt.Sprintf("Bad revision number %q", number)}
+	}
+	rev, err := revision.Get(web.persister, resource, n)
+	if err == persist.ErrNotFound {
+		return nil, webErr{status: 404, body: "No such revision"}
+	} else if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+func (web *Web) listRevisions(c *context) error {
+	revs, err := revision.List(web.persister, c.vars["resource"])
+	if err != nil {
+		return err
+	}
+	summaries := make([]revisionSummary, len(revs))
+	for index, rev := range revs {
+		summaries[index] = revisionSummary{
+			Number:    rev.Number,
+			Author:    rev.Author,
+			CodeHash:  rev.CodeHash,
+			Binary:    rev.Binary,
+			Broken:    rev.Broken,
+			CreatedAt: rev.CreatedAt,
+		}
+	}
+	c.resp.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	return json.NewEncoder(c.resp).Encode(summaries)
+}
+
+func (web *Web) getRevision(c *context) error {
+	rev, err := web.revision(c.vars["resource"], c.vars["number"])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(c.resp, rev.Code)
+	return err
+}
+
+// diffRevision diffs the revision against the revision in the against parameter, or the current code.
+func (web *Web) diffRevision(c *context) error {
+	rev, err := web.revision(c.vars["resource"], c.vars["number"])
+	if err != nil {
+		return err
+	}
+	against := ""
+	if param := c.req.URL.Query().Get("against"); param != "" {
+		other, err := web.revision(c.vars["resource"], param)
+		if err != nil {
+			return err
+		}
+		against = other.Code
+	} else {
+		res := &resource.Resource{}
+		if err := web.persister.Get(rev.Resource, res); err != nil {
+			return err
+		}
+		against = res.Code
+	}
+	_, err = io.WriteString(c.resp, revision.Diff(rev.Code, against))
+	return err
+}
+
+func (web *Web) restoreRevision(c *context) error {
+	res := &resource.Resource{}
+	if err := web.persister.Get(c.vars["resource"], res); err == persist.ErrNotFound {
+		return webErr{status: 404, body: err.Error()}
+	} else if err != nil {
+		return err
+	}
+
+	if res.Owner != c.user.Resource {
+		return webErr{status: 403, body: "Not owner"}
+	}
+
+	rev, err := web.revision(c.vars["resource"], c.vars["number"])
+	if err != nil {
+		return err
+	}
+	return web.store(c, res, rev.Code)
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zond/hackyhack/server/credentials"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/session"
+	"github.com/zond/hackyhack/server/user"
+)
+
+const (
+	sessionCookie        = "hackyhack-session"
+	sessionSweepInterval = time.Hour
+)
+
+var errUnauthenticated = fmt.Errorf("Unauthenticated")
+
+type loginResponse struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// requestToken returns the session token of r, from the Authorization header or the session cookie.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (web *Web) sessionUser(s *session.Session) (*user.User, error) {
+	u := &user.User{}
+	if err := web.persister.Get(s.Username, u); err == persist.ErrNotFound {
+		return nil, errUnauthenticated
+	} else if err != nil {
+		return nil, err
+	}
+	if u.Resource != s.Resource {
+		return nil, errUnauthenticated
+	}
+	return u, nil
+}
+
+// authenticate returns the user of r from its bearer token or session cookie.
+func (web *Web) authenticate(r *http.Request) (*user.User, error) {
+	token := requestToken(r)
+	if token == "" {
+		return nil, errUnauthenticated
+	}
+	s, err := session.Get(web.persister, token)
+	if err == session.ErrInvalid {
+		return nil, errUnauthenticated
+	} else if err != nil {
+		return nil, err
+	}
+	return web.sessionUser(s)
+}
+
+// unauthenticated sends browsers asking for a page to the login page, and refuses everything else.
+func unauthenticated(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, fmt.Sprintf("/login?next=%v", url.QueryEscape(r.URL.RequestURI())), 303)
+		return
+	}
+	http.Error(w, "Unauthenticated", 401)
+}
+
+// sweepSessions removes expired sessions every sessionSweepInterval.
+func (web *Web) sweepSessions() {
+	for now := range time.Tick(sessionSweepInterval) {
+		if err := session.Sweep(web.persister, now); err != nil {
+			log.Printf("Sweeping sessions failed: %v", err)
+		}
+	}
+}
+
+// login starts a session for username if passwd matches, and sets the session cookie.
+func (web *Web) login(w http.ResponseWriter, username, passwd string) (*user.User, string, *session.Session, error) {
+	u, err := credentials.Authenticate(web.persister, username, passwd)
+	if err == credentials.ErrUnknownUser || err == credentials.ErrWrongPassword {
+		return nil, "", nil, errUnauthenticated
+	} else if err != nil {
+		return nil, "", nil, err
+	}
+	token, s, err := session.Create(web.persister, u)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	setSessionCookie(w, token, s.ExpiresAt)
+	return u, token, s, nil
+}
+
+// requireTLS redirects requests not using TLS to https, and returns whether it did.
+func requireTLS(w http.ResponseWriter, r *http.Request) bool {
+	if r.TLS != nil {
+		return false
+	}
+	newURL := r.URL
+	newURL.Scheme = "https"
+	http.Redirect(w, r, newURL.String(), 301)
+	return true
+}
+
+// getLogin serves the login page, which posts to postLogin and then goes to the next query value.
+func (web *Web) getLogin(w http.ResponseWriter, r *http.Request) {
+	if requireTLS(w, r) {
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(
+		os.Getenv("GOPATH"),
+		"src",
+		"github.com",
+		"zond",
+		"hackyhack",
+		"server",
+		"web",
+		"static",
+		"login.html",
+	))
+}
+
+// postLogin starts a session for the username and password form values, and returns its token
+// both as a cookie and in the body, for clients preferring bearer tokens.
+func (web *Web) postLogin(w http.ResponseWriter, r *http.Request) {
+	if requireTLS(w, r) {
+		return
+	}
+	_, token, s, err := web.login(w, r.FormValue("username"), r.FormValue("password"))
+	if err == errUnauthenticated {
+		http.Error(w, "Unauthenticated", 401)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(loginResponse{
+		Token:     token,
+		ExpiresAt: s.ExpiresAt,
+	}); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// postLogout revokes the session of the request, if any.
+func (web *Web) postLogout(w http.ResponseWriter, r *http.Request) {
+	if requireTLS(w, r) {
+		return
+	}
+	if token := requestToken(r); token != "" {
+		if err := session.Revoke(web.persister, token); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(204)
+}
+
+// webAuth redeems a one-time token from the webauth command, and sends the user to the editor
+// for their avatar.
+func (web *Web) webAuth(w http.ResponseWriter, r *http.Request) {
+	if requireTLS(w, r) {
+		return
+	}
+	token, s, err := session.Redeem(web.persister, mux.Vars(r)["token"])
+	if err == session.ErrInvalid {
+		http.Error(w, err.Error(), 401)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	setSessionCookie(w, token, s.ExpiresAt)
+	http.Redirect(w, r, fmt.Sprintf("/edit/%v", s.Resource), 303)
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/session"
+	"github.com/zond/hackyhack/server/user"
+)
+
+func TestAuthenticated(t *testing.T) {
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if err := p.Index(session.Session{}, "Username"); err != nil {
+		t.Fatal(err)
+	}
+	u := &user.User{Username: "bob", Resource: "r"}
+	if err := p.Put(u.Username, u); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := session.Create(p, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := &Web{
+		persister: p,
+		muxRouter: mux.NewRouter(),
+	}
+	handler := web.authenticated(func(c *context) error {
+		c.resp.Write([]byte(c.user.Username))
+		return nil
+	})
+
+	for _, tc := range []struct {
+		name       string
+		prepare    func(*http.Request)
+		wantStatus int
+		wantHeader string
+		wantValue  string
+	}{
+		{"bearer", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}, 200, "", ""},
+		{"cookie", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+		}, 200, "", ""},
+		{"bad bearer", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer not"+token)
+		}, 401, "WWW-Authenticate", ""},
+		{"basic is no longer enough", func(r *http.Request) {
+			r.SetBasicAuth("bob", "secret")
+		}, 401, "Set-Cookie", ""},
+		{"browsers go to the login page", func(r *http.Request) {
+			r.Header.Set("Accept", "text/html,application/xhtml+xml")
+		}, 303, "Location", "/login?next=%2Fedit%2Fr%3Fx%3D1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://localhost/edit/r?x=1", nil)
+			r.TLS = &tls.ConnectionState{}
+			tc.prepare(r)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tc.wantStatus {
+				t.Errorf("Got status %v, wanted %v", w.Code, tc.wantStatus)
+			}
+			if w.Code == 200 && w.Body.String() != "bob" {
+				t.Errorf("Got %q, wanted bob authenticated", w.Body.String())
+			}
+			if tc.wantHeader != "" {
+				if got := w.Header().Get(tc.wantHeader); got != tc.wantValue {
+					t.Errorf("Got %v %q, wanted %q", tc.wantHeader, got, tc.wantValue)
+				}
+			}
+		})
+	}
+}
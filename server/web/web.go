@@ -1,13 +1,14 @@
 package web
 
 import (
-	"crypto/hmac"
+	"bufio"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -16,8 +17,10 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/zond/hackyhack/logging"
+	"github.com/zond/hackyhack/proc/build"
 	"github.com/zond/hackyhack/server/persist"
 	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
 	"github.com/zond/hackyhack/server/router"
 	"github.com/zond/hackyhack/server/router/validator"
 	"github.com/zond/hackyhack/server/user"
@@ -25,10 +28,6 @@ import (
 
 var editorTmpl *template.Template
 
-const (
-	realm = "hackyhack"
-)
-
 func init() {
 	editorTmpl = template.Must(template.ParseFiles(filepath.Join(
 		os.Getenv("GOPATH"),
@@ -64,6 +63,7 @@ type Web struct {
 	persister  *persist.Persister
 	muxRouter  *mux.Router
 	hackRouter *router.Router
+	buildCache *build.Cache
 }
 
 type memRespWriter struct {
@@ -84,6 +84,22 @@ func (m *memRespWriter) WriteHeader(i int) {
 	m.ResponseWriter.WriteHeader(i)
 }
 
+func (m *memRespWriter) Flush() {
+	if flusher, ok := m.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets web sockets take over the connection.
+func (m *memRespWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := m.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T doesn't support hijacking", m.ResponseWriter)
+	}
+	m.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
 func (web *Web) log(f func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		memW := &memRespWriter{
@@ -102,11 +118,12 @@ func (web *Web) log(f func(w http.ResponseWriter, r *http.Request)) http.Handler
 	}
 }
 
-func New(p *persist.Persister, r *router.Router) *Web {
+func New(p *persist.Persister, r *router.Router, c *build.Cache) *Web {
 	web := &Web{
 		persister:  p,
 		muxRouter:  mux.NewRouter(),
 		hackRouter: r,
+		buildCache: c,
 	}
 	web.muxRouter.Path("/favicon.ico").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { http.Error(w, "Not found", 404) })
 	web.muxRouter.PathPrefix("/static").HandlerFunc(web.log(http.StripPrefix("/static", http.FileServer(http.Dir(filepath.Join(
@@ -119,9 +136,21 @@ func New(p *persist.Persister, r *router.Router) *Web {
 		"web",
 		"static",
 	)))).ServeHTTP))
+	web.muxRouter.Path("/login").Methods("GET").HandlerFunc(web.log(web.getLogin))
+	web.muxRouter.Path("/login").Methods("POST").HandlerFunc(web.log(web.postLogin))
+	web.muxRouter.Path("/logout").Methods("POST").HandlerFunc(web.log(web.postLogout))
+	web.muxRouter.Path("/webauth/{token}").Methods("GET").HandlerFunc(web.log(web.webAuth))
+	web.muxRouter.Path("/play").Methods("GET").HandlerFunc(web.log(web.play))
+	web.muxRouter.Path("/play/socket").Methods("GET").HandlerFunc(web.log(web.playSocket))
 	web.muxRouter.Path("/edit/{resource}").Methods("GET").HandlerFunc(web.authenticated(web.editor))
+	web.muxRouter.Path("/{resource}/logs").Methods("GET").HandlerFunc(web.authenticated(web.streamLogs))
+	web.muxRouter.Path("/{resource}/revisions").Methods("GET").HandlerFunc(web.authenticated(web.listRevisions))
+	web.muxRouter.Path("/{resource}/revisions/{number}").Methods("GET").HandlerFunc(web.authenticated(web.getRevision))
+	web.muxRouter.Path("/{resource}/revisions/{number}/diff").Methods("GET").HandlerFunc(web.authenticated(web.diffRevision))
+	web.muxRouter.Path("/{resource}/revisions/{number}/restore").Methods("POST").HandlerFunc(web.authenticated(web.restoreRevision))
 	web.muxRouter.Path("/{resource}").Methods("GET").HandlerFunc(web.authenticated(web.getResource))
 	web.muxRouter.Path("/{resource}").Methods("PUT").HandlerFunc(web.authenticated(web.putResource))
+	go web.sweepSessions()
 	return web
 }
 
@@ -131,46 +160,19 @@ func (web *Web) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (web *Web) authenticated(f func(*context) error) http.HandlerFunc {
 	return web.log(func(w http.ResponseWriter, r *http.Request) {
-		if r.TLS == nil {
-			newURL := r.URL
-			newURL.Scheme = "https"
-			http.Redirect(w, r, newURL.String(), 301)
+		if requireTLS(w, r) {
 			return
 		}
 
-		username, passwd, ok := r.BasicAuth()
-		if !ok {
-			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
-			http.Error(w, "Unauthenticated", 401)
+		user, err := web.authenticate(r)
+		if err == errUnauthenticated {
+			unauthenticated(w, r)
 			return
-		}
-
-		users := []user.User{}
-		if err := web.persister.Find(persist.NewF(user.User{
-			Username: username,
-		}).Add("Username"), &users); err != nil {
+		} else if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
 
-		if len(users) == 0 {
-			http.Error(w, "Unauthenticated", 401)
-			return
-		}
-
-		var user *user.User
-		for _, found := range users {
-			if hmac.Equal([]byte(found.Password), []byte(passwd)) {
-				user = &found
-				break
-			}
-		}
-
-		if user == nil {
-			http.Error(w, "Unauthenticated", 401)
-			return
-		}
-
 		if err := f(&context{
 			user: user,
 			req:  r,
@@ -179,6 +181,10 @@ func (web *Web) authenticated(f func(*context) error) http.HandlerFunc {
 		}); err != nil {
 			if werr, ok := err.(webErr); ok {
 				http.Error(w, werr.body, werr.status)
+			} else if derr, ok := err.(diagnosticsErr); ok {
+				if err := writeDiagnostics(w, derr.status, derr.code, derr.diagnostics); err != nil {
+					log.Printf("Writing diagnostics failed: %v", err)
+				}
 			} else {
 				http.Error(w, err.Error(), 500)
 			}
@@ -226,8 +232,7 @@ func (web *Web) putResource(c *context) error {
 		return webErr{status: 403, body: "Not owner"}
 	}
 
-	tmpFileBase := filepath.Join(os.TempDir(), fmt.Sprintf("%x%x", rand.Int63(), rand.Int63()))
-	tmpFileName := fmt.Sprintf("%v.go", tmpFileBase)
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("%x%x.go", rand.Int63(), rand.Int63()))
 	tmpFile, err := os.Create(tmpFileName)
 	if err != nil {
 		return err
@@ -244,37 +249,65 @@ func (web *Web) putResource(c *context) error {
 	}
 	defer os.Remove(tmpFileName)
 
-	output, err := exec.Command("goimports", "-w", tmpFileName).CombinedOutput()
+	output, importsErr := exec.Command("goimports", "-w", tmpFileName).CombinedOutput()
+	if _, isExit := importsErr.(*exec.ExitError); importsErr != nil && !isExit {
+		return importsErr
+	}
+
+	// Unless goimports failed, it rewrote the file, and the editor has to show the rewritten code
+	// for the positions of the diagnostics to make sense.
+	body, err := ioutil.ReadFile(tmpFileName)
 	if err != nil {
 		return err
 	}
-	if len(output) > 0 {
-		return webErr{status: 400, body: string(output)}
+	if importsErr != nil || len(output) > 0 {
+		return diagnosticsErr{status: 400, code: string(body), diagnostics: parseToolOutput(string(output), codeFile(res), SourceGoimports, SeverityError)}
 	}
 
-	body, err := ioutil.ReadFile(tmpFileName)
-	if err != nil {
+	if err := web.store(c, res, string(body)); err != nil {
 		return err
 	}
 
-	if err := validator.Validate(string(body)); err != nil {
-		return webErr{status: 400, body: err.Error()}
+	// The code is already running, so vet only has opinions.
+	output, err = exec.Command("go", "vet", tmpFileName).CombinedOutput()
+	if _, isExit := err.(*exec.ExitError); err != nil && !isExit {
+		log.Printf("go vet failed: %v", err)
+		output = nil
 	}
+	return writeDiagnostics(c.resp, 200, string(body), parseToolOutput(string(output), codeFile(res), SourceVet, SeverityWarning))
+}
 
-	output, err = exec.Command("go", "build", "-o", tmpFileBase, tmpFileName).CombinedOutput()
+// codeFile is the file name diagnostics for the code of res refer to.
+func codeFile(res *resource.Resource) string {
+	return fmt.Sprintf("%v.go", res.Id)
+}
+
+// store validates and builds code, and saves it as a new revision and the code of res before restarting it.
+func (web *Web) store(c *context, res *resource.Resource, code string) error {
+	if err := validator.Validate(code); err != nil {
+		return diagnosticsErr{status: 400, code: code, diagnostics: validationDiagnostics(err, codeFile(res))}
+	}
+
+	binary, err := web.buildCache.Binary(code)
 	if err != nil {
+		if berr, ok := err.(*build.Error); ok {
+			return diagnosticsErr{status: 400, code: code, diagnostics: parseToolOutput(berr.Output, codeFile(res), SourceBuild, SeverityError)}
+		}
 		return err
 	}
-	if len(output) > 0 {
-		return webErr{status: 400, body: string(output)}
-	}
 
 	if err := web.persister.Transact(func(p *persist.Persister) error {
 		if err := p.Get(res.Id, res); err != nil {
 			return err
 		}
-		res.Code = string(body)
-		return p.Put(res.Id, res)
+		res.Code = code
+		res.Broken = false
+		res.BrokenReason = ""
+		if err := p.Put(res.Id, res); err != nil {
+			return err
+		}
+		_, err := revision.Save(p, res.Id, c.user.Resource, code, filepath.Base(binary))
+		return err
 	}); err != nil {
 		return err
 	}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zond/hackyhack/proc/build"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/server/persist"
+	"github.com/zond/hackyhack/server/resource"
+	"github.com/zond/hackyhack/server/revision"
+	"github.com/zond/hackyhack/server/router"
+	"github.com/zond/hackyhack/server/user"
+)
+
+const fixedCode = `package main
+
+import (
+	"github.com/zond/hackyhack/proc/interfaces"
+	"github.com/zond/hackyhack/proc/messages"
+	"github.com/zond/hackyhack/proc/slave"
+)
+
+type handler struct{}
+
+func New(m interfaces.MCP) interfaces.Describable {
+	return &handler{}
+}
+
+func (h *handler) GetShortDesc() (*messages.ShortDesc, *messages.Error) {
+	return &messages.ShortDesc{
+		Value: "fixed",
+	}, nil
+}
+
+func (h *handler) GetLongDesc() (string, *messages.Error) {
+	return "", nil
+}
+
+func main() {
+	slave.Register(New)
+}
+`
+
+func TestStoreUnbreaks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := &persist.Persister{Backend: persist.NewMem()}
+	if err := p.Index(resource.Resource{}, "Owner", "Container"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Index(revision.Revision{}, "Resource"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := build.NewCache(filepath.Join(dir, "build"), build.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := router.New(p, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Decomission(messages.VoidResource)
+
+	res := &resource.Resource{
+		Id:           "res",
+		Owner:        "owner",
+		Code:         "package main",
+		Broken:       true,
+		BrokenReason: "crashed",
+	}
+	if err := p.Put(res.Id, res); err != nil {
+		t.Fatal(err)
+	}
+	web := &Web{
+		persister:  p,
+		hackRouter: r,
+		buildCache: c,
+	}
+	if err := web.store(&context{user: &user.User{Resource: "owner"}}, res, fixedCode); err != nil {
+		t.Fatal(err)
+	}
+	stored := &resource.Resource{}
+	if err := p.Get(res.Id, stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Broken || stored.BrokenReason != "" || stored.Code != fixedCode {
+		t.Errorf("Got %+v, wanted the new code no longer broken", stored)
+	}
+	if revs, err := revision.List(p, res.Id); err != nil || len(revs) != 1 || revs[0].Author != "owner" {
+		t.Errorf("Got revisions %+v, %v, wanted one by the owner", revs, err)
+	}
+	defer r.Decomission(res.Id)
+	if !r.Running(res.Id) {
+		t.Errorf("Wanted the fixed resource running")
+	}
+}
+
+func TestStoreDiagnosticsKeepCode(t *testing.T) {
+	web := &Web{}
+	code := "package main\n\nimport \"os\"\n"
+	err := web.store(&context{user: &user.User{Resource: "owner"}}, &resource.Resource{Id: "res"}, code)
+	derr, ok := err.(diagnosticsErr)
+	if !ok {
+		t.Fatalf("Got %v, wanted diagnostics", err)
+	}
+	if derr.code != code || len(derr.diagnostics) == 0 || derr.diagnostics[0].File != "res.go" {
+		t.Errorf("Got %+v, wanted diagnostics for res.go positioned in the stored code", derr)
+	}
+}